@@ -0,0 +1,107 @@
+package godoit
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"github.com/robfig/cron"
+)
+
+// maxBlackoutWindows limits how many windows are searched for the end of a
+// blackout or the next run outside one, so windows covering all time end
+const maxBlackoutWindows = 10000
+
+// BlackoutWindow is a period during which no jobs start, such as a patch
+// window. It starts at the times of a cronspec and lasts for a duration.
+type BlackoutWindow struct {
+	Spec CronSpec
+	Duration time.Duration
+	schedule cron.Schedule
+}
+
+// ParseBlackoutWindow parses a blackout window of the form
+// '<cronspec> [timezone] <duration>', the cronspec is in UTC if no timezone is
+// given
+func ParseBlackoutWindow(value string) (BlackoutWindow, error) {
+	fields := strings.Fields(value)
+	if len(fields) > 1 {
+		duration, err := time.ParseDuration(fields[len(fields)-1])
+		spec, specErr := parseCronSpec(strings.Join(fields[:len(fields)-1], " "))
+		if err == nil && specErr == nil && duration > 0 {
+			location := spec.Timezone
+			if location == nil {
+				location = time.UTC
+			}
+			schedule, _ := cron.Parse(spec.Spec)
+			return BlackoutWindow{spec, duration, locationSchedule{schedule, location}}, nil
+		}
+	}
+	return BlackoutWindow{}, fmt.Errorf("Invalid blackout: '%s'", value)
+}
+
+// String returns the blackout window in the form it is parsed from
+func (window BlackoutWindow) String() string {
+	return fmt.Sprintf("%s %s", window.Spec, window.Duration)
+}
+
+// Contains returns whether the time is in a blackout window
+func (window BlackoutWindow) Contains(t time.Time) bool {
+	start := window.schedule.Next(t.Add(-window.Duration))
+	return !start.IsZero() && !start.After(t)
+}
+
+// end returns the end of the blackout containing t, including any windows
+// which overlap it
+func (window BlackoutWindow) end(t time.Time) time.Time {
+	for i := 0; i < maxBlackoutWindows; i++ {
+		start := window.schedule.Next(t.Add(-window.Duration))
+		if start.IsZero() || start.After(t) {
+			return t
+		}
+		t = start.Add(window.Duration)
+	}
+	return time.Time{}
+}
+
+// blackoutWindowContaining returns the first of the windows which contains t
+func blackoutWindowContaining(windows []BlackoutWindow, t time.Time) (BlackoutWindow, bool) {
+	for _, window := range windows {
+		if window.Contains(t) {
+			return window, true
+		}
+	}
+	return BlackoutWindow{}, false
+}
+
+// blackoutSchedule skips the runs of a schedule during blackout windows
+type blackoutSchedule struct {
+	schedule cron.Schedule
+	windows []BlackoutWindow
+}
+
+func (schedule blackoutSchedule) Next(t time.Time) time.Time {
+	next, _ := schedule.next(t)
+	return next
+}
+
+// next returns the first run after t outside the blackout windows and a note
+// on the first run skipped if there was one
+func (schedule blackoutSchedule) next(t time.Time) (time.Time, string) {
+	next, note := nextWithNote(schedule.schedule, t)
+	skipped := ""
+	for i := 0; i < maxBlackoutWindows && !next.IsZero(); i++ {
+		window, ok := blackoutWindowContaining(schedule.windows, next)
+		if !ok {
+			return next, joinNotes(skipped, note)
+		}
+		if skipped == "" {
+			skipped = fmt.Sprintf("skipped %s in blackout %s", next.Format("2006-01-02 15:04:05 MST"), window)
+		}
+		end := window.end(next)
+		if end.IsZero() {
+			break
+		}
+		next, note = nextWithNote(schedule.schedule, end.Add(-time.Nanosecond))
+	}
+	return time.Time{}, ""
+}
@@ -0,0 +1,297 @@
+package godoit
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"github.com/robfig/cron"
+)
+
+// Policies for the runs of a job which fall on a date in the job's calendar
+const (
+	// CalendarSkip does not run the job on dates in the calendar
+	CalendarSkip = "skip"
+	// CalendarNextBusinessDay runs the job at the same time on the next day
+	// which is not in the calendar or a weekend
+	CalendarNextBusinessDay = "next-business-day"
+	// CalendarPreviousBusinessDay runs the job at the same time on the previous
+	// day which is not in the calendar or a weekend
+	CalendarPreviousBusinessDay = "previous-business-day"
+)
+
+// maxCalendarDays limits how many days a run is moved to find a business day
+const maxCalendarDays = 14
+
+func isCalendarPolicy(policy string) bool {
+	return policy == CalendarSkip || policy == CalendarNextBusinessDay || policy == CalendarPreviousBusinessDay
+}
+
+// applyCalendarParameter parses a calendar of the form
+// '<name> [skip|next-business-day|previous-business-day]', the policy for runs
+// on the dates in the calendar defaults to skip.
+func applyCalendarParameter(job *Job, value string) {
+	parts := strings.Fields(value)
+	valid := (len(parts) == 1 || (len(parts) == 2 && isCalendarPolicy(parts[1])))
+	if valid && IsCalendar(parts[0]) {
+		job.Calendar = parts[0]
+		job.CalendarPolicy = CalendarSkip
+		if len(parts) == 2 {
+			job.CalendarPolicy = parts[1]
+		}
+		return
+	}
+	job.Errors = append(job.Errors, fmt.Sprintf("Invalid calendar: '%s'", value))
+}
+
+// Calendar is a set of dates, such as public holidays, keyed by the date in
+// the form 2006-01-02
+type Calendar map[string]bool
+
+// Contains returns whether the date of the time, in its location, is in the
+// calendar
+func (calendar Calendar) Contains(t time.Time) bool {
+	return calendar[t.Format("2006-01-02")]
+}
+
+var calendarsLock sync.Mutex
+var calendars = map[string]Calendar{}
+
+// RegisterCalendar makes a calendar available to jobs by name, replacing any
+// calendar with the same name. Calendars must be registered before jobs using
+// them are parsed.
+func RegisterCalendar(name string, calendar Calendar) {
+	calendarsLock.Lock()
+	defer calendarsLock.Unlock()
+	calendars[name] = calendar
+}
+
+// UnregisterCalendar removes the calendar with the name, jobs already using it
+// no longer have any dates moved or skipped
+func UnregisterCalendar(name string) {
+	calendarsLock.Lock()
+	defer calendarsLock.Unlock()
+	delete(calendars, name)
+}
+
+// IsCalendar returns whether a calendar is registered with the name
+func IsCalendar(name string) bool {
+	_, ok := findCalendar(name)
+	return ok
+}
+
+func findCalendar(name string) (Calendar, bool) {
+	calendarsLock.Lock()
+	defer calendarsLock.Unlock()
+	calendar, ok := calendars[name]
+	return calendar, ok
+}
+
+// LoadCalendars registers the calendars in the directory, named after each
+// `<name>.ics` or `<name>.dates` file, and returns their names. No calendar is
+// registered if any of the files is invalid.
+func LoadCalendars(directory string) ([]string, error) {
+	loaded, err := ReadCalendars(directory)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(loaded))
+	for name, calendar := range loaded {
+		RegisterCalendar(name, calendar)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ReadCalendars reads the calendars in the directory, keyed by name, without
+// registering them
+func ReadCalendars(directory string) (map[string]Calendar, error) {
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read calendar directory %s", directory)
+	}
+	loaded := make(map[string]Calendar)
+	for _, file := range files {
+		extension := filepath.Ext(file.Name())
+		if file.IsDir() || (extension != ".ics" && extension != ".dates") {
+			continue
+		}
+		calendar, err := ReadCalendar(filepath.Join(directory, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		loaded[strings.TrimSuffix(file.Name(), extension)] = calendar
+	}
+	return loaded, nil
+}
+
+// ReadCalendar reads the dates of the events in an ICS file, or a file with a
+// date such as 2017-12-25 at the start of each line and `#` for comments.
+func ReadCalendar(calendarFile string) (Calendar, error) {
+	file, err := os.Open(calendarFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read calendar %s", calendarFile)
+	}
+	defer file.Close()
+
+	calendar := make(Calendar)
+	ics := filepath.Ext(calendarFile) == ".ics"
+	var start time.Time
+	scanner := bufio.NewScanner(file)
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if ics {
+			if err := readICSLine(line, calendar, &start); err != nil {
+				return nil, fmt.Errorf("%s in %s line %d", err, calendarFile, i)
+			}
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		date, err := time.Parse("2006-01-02", strings.Fields(line)[0])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid date '%s' in %s line %d", line, calendarFile, i)
+		}
+		calendar[date.Format("2006-01-02")] = true
+	}
+	return calendar, nil
+}
+
+// readICSLine adds the dates of each event, from its DTSTART up to its DTEND
+// which is not included
+func readICSLine(line string, calendar Calendar, start *time.Time) error {
+	parts := strings.SplitN(line, ":", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	name := strings.SplitN(parts[0], ";", 2)[0]
+	if name == "BEGIN" && parts[1] == "VEVENT" {
+		*start = time.Time{}
+	} else if name == "DTSTART" || name == "DTEND" {
+		date, midnight, err := parseICSDate(parts[1])
+		if err != nil {
+			return fmt.Errorf("Invalid %s '%s'", name, parts[1])
+		}
+		if name == "DTSTART" {
+			*start = date
+			calendar[date.Format("2006-01-02")] = true
+		} else {
+			if !midnight {
+				// An event ending during a day includes that day
+				date = date.AddDate(0, 0, 1)
+			}
+			for day := *start; !start.IsZero() && day.Before(date); day = day.AddDate(0, 0, 1) {
+				calendar[day.Format("2006-01-02")] = true
+			}
+		}
+	}
+	return nil
+}
+
+// parseICSDate returns the date of an ICS DATE or DATE-TIME value and whether
+// the time is midnight. A time in UTC, ending in `Z`, is on its date in the
+// local timezone, other times are on the date they are written with, in the
+// timezone of their TZID or the local timezone.
+func parseICSDate(value string) (time.Time, bool, error) {
+	if len(value) == 8 {
+		date, err := time.Parse("20060102", value)
+		return date, true, err
+	}
+	t, err := time.Parse("20060102T150405", strings.TrimSuffix(value, "Z"))
+	if err != nil {
+		return t, false, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t = t.In(time.Local)
+	}
+	date := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return date, t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0, nil
+}
+
+// calendarSchedule moves or skips the runs of a schedule which fall on the
+// dates in a calendar. The calendar is found by name for each run, so runs
+// follow the calendar when it is loaded again.
+type calendarSchedule struct {
+	schedule cron.Schedule
+	name string
+	policy string
+}
+
+func (schedule calendarSchedule) Next(t time.Time) time.Time {
+	next, _ := schedule.next(t)
+	return next
+}
+
+// next returns the first run after t and a note if it was moved or runs were
+// skipped. Runs are moved by up to maxCalendarDays, so runs from that long
+// before t may be moved after it and, for previous-business-day, runs that long
+// after the first ordinary run may be moved before it.
+func (schedule calendarSchedule) next(t time.Time) (time.Time, string) {
+	calendar, _ := findCalendar(schedule.name)
+	window := maxCalendarDays * 24 * time.Hour
+	var best time.Time
+	bestNote, skipped := "", ""
+	for original := schedule.schedule.Next(t.Add(-window)); !original.IsZero(); {
+		if !best.IsZero() && original.After(best.Add(window)) {
+			break
+		}
+		if !calendar.Contains(original) {
+			if original.After(t) && (best.IsZero() || original.Before(best)) {
+				best, bestNote = nextWithNote(schedule.schedule, original.Add(-time.Nanosecond))
+			}
+			if original.After(t) && schedule.policy != CalendarPreviousBusinessDay {
+				// Runs are only moved later, so no later run is earlier
+				break
+			}
+			// The other runs on an ordinary day are not moved, so only the
+			// first after t can be the next
+			end := time.Date(original.Year(), original.Month(), original.Day(), 23, 59, 59, 0, original.Location())
+			if t.After(original) && t.Before(end) {
+				end = t
+			}
+			original = schedule.schedule.Next(end)
+			continue
+		}
+
+		note := fmt.Sprintf("%s is in calendar %s", original.Format("2006-01-02"), schedule.name)
+		moved, ok := schedule.move(calendar, original)
+		if ok && moved.After(t) && (best.IsZero() || moved.Before(best)) {
+			best, bestNote = moved, "moved from " + note
+		} else if !ok && original.After(t) && skipped == "" {
+			skipped = "skipped " + note
+		}
+		original = schedule.schedule.Next(original)
+	}
+	if best.IsZero() {
+		return best, ""
+	}
+	return best, joinNotes(skipped, bestNote)
+}
+
+// move returns the time on the business day the run on a date in the calendar
+// is moved to, if the policy moves it
+func (schedule calendarSchedule) move(calendar Calendar, original time.Time) (time.Time, bool) {
+	step := 1
+	if schedule.policy == CalendarSkip {
+		return time.Time{}, false
+	} else if schedule.policy == CalendarPreviousBusinessDay {
+		step = -1
+	}
+	for days := step; days <= maxCalendarDays && days >= -maxCalendarDays; days += step {
+		moved := time.Date(
+			original.Year(), original.Month(), original.Day() + days,
+			original.Hour(), original.Minute(), original.Second(), 0, original.Location())
+		weekend := moved.Weekday() == time.Saturday || moved.Weekday() == time.Sunday
+		if !weekend && !calendar.Contains(moved) {
+			return moved, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,165 @@
+package godoit
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cpuPeriod is the cpu.max period in microseconds used for cpu quotas
+const cpuPeriod = 100000
+
+var cgroupNameRegex = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// jobCgroup is a cgroup v2 sub-group created under the configured parent for a
+// single run of a job.
+type jobCgroup struct {
+	path string
+	dir *os.File
+}
+
+// newJobCgroup creates a cgroup for a run of the job and applies the memory and
+// cpu limits of the job to it.
+func newJobCgroup(parent string, job Job) (*jobCgroup, error) {
+	if _, err := os.Stat(parent); os.IsNotExist(err) && isCgroup(filepath.Dir(parent)) {
+		os.Mkdir(parent, 0755)
+	}
+	if !isCgroup(parent) {
+		return nil, fmt.Errorf("%s is not a cgroup v2 group", parent)
+	}
+
+	// Enable the controllers for the job groups, the parent may already have them
+	enableControllers(parent, job)
+
+	name := fmt.Sprintf("%s-%d", cgroupNameRegex.ReplaceAllString(job.Name, "_"), time.Now().UnixNano())
+	path := filepath.Join(parent, name)
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, err
+	}
+	dir, err := os.Open(path)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	cgroup := &jobCgroup{path, dir}
+
+	if job.Limits.Memory > 0 {
+		memory := "max"
+		if job.Limits.Memory != unlimited {
+			memory = strconv.FormatUint(job.Limits.Memory, 10)
+		}
+		cgroup.write("memory.max", memory)
+	}
+	if job.Limits.CPU > 0 {
+		cgroup.write("cpu.max", fmt.Sprintf("%d %d", job.Limits.CPU * cpuPeriod / 100, cpuPeriod))
+	}
+	return cgroup, nil
+}
+
+func isCgroup(path string) bool {
+	_, err := os.Stat(filepath.Join(path, "cgroup.controllers"))
+	return err == nil
+}
+
+func enableControllers(parent string, job Job) {
+	controllers := []string{}
+	if job.Limits.Memory > 0 {
+		controllers = append(controllers, "+memory")
+	}
+	if job.Limits.CPU > 0 {
+		controllers = append(controllers, "+cpu")
+	}
+	if len(controllers) > 0 {
+		subtreeControl := filepath.Join(parent, "cgroup.subtree_control")
+		if err := ioutil.WriteFile(subtreeControl, []byte(strings.Join(controllers, " ")), 0644); err != nil {
+			log.Printf("WARNING: Unable to enable cgroup controllers %v in %s: %s", controllers, parent, err)
+		}
+	}
+}
+
+func (cgroup *jobCgroup) write(file, value string) {
+	if err := ioutil.WriteFile(filepath.Join(cgroup.path, file), []byte(value), 0644); err != nil {
+		log.Printf("WARNING: Unable to set %s to %s for %s: %s", file, value, cgroup.path, err)
+	}
+}
+
+// procAttr places the process in the cgroup as it is started
+func (cgroup *jobCgroup) procAttr(attr *syscall.SysProcAttr) *syscall.SysProcAttr {
+	if attr == nil {
+		attr = &syscall.SysProcAttr{}
+	}
+	attr.UseCgroupFD = true
+	attr.CgroupFD = int(cgroup.dir.Fd())
+	return attr
+}
+
+// signal sends the signal to every process in the cgroup
+func (cgroup *jobCgroup) signal(signal syscall.Signal) error {
+	procs, err := ioutil.ReadFile(filepath.Join(cgroup.path, "cgroup.procs"))
+	if err != nil {
+		return err
+	}
+	for _, proc := range strings.Fields(string(procs)) {
+		if pid, err := strconv.Atoi(proc); err == nil {
+			syscall.Kill(pid, signal)
+		}
+	}
+	return nil
+}
+
+// kill kills every process in the cgroup and waits for them to exit
+func (cgroup *jobCgroup) kill() error {
+	if err := ioutil.WriteFile(filepath.Join(cgroup.path, "cgroup.kill"), []byte("1"), 0644); err != nil {
+		// Kernels before 5.14 do not support cgroup.kill
+		if err := cgroup.signal(syscall.SIGKILL); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < 50 && cgroup.populated(); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil
+}
+
+func (cgroup *jobCgroup) populated() bool {
+	events, err := ioutil.ReadFile(filepath.Join(cgroup.path, "cgroup.events"))
+	return err == nil && strings.Contains(string(events), "populated 1")
+}
+
+// stats reads the peak memory and cpu time used by the cgroup
+func (cgroup *jobCgroup) stats(result *RunResult) {
+	if peak, err := ioutil.ReadFile(filepath.Join(cgroup.path, "memory.peak")); err == nil {
+		result.PeakMemory, _ = strconv.ParseUint(strings.TrimSpace(string(peak)), 10, 64)
+	}
+	if file, err := os.Open(filepath.Join(cgroup.path, "cpu.stat")); err == nil {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 2 && fields[0] == "usage_usec" {
+				usage, _ := strconv.ParseInt(fields[1], 10, 64)
+				result.CPUTime = time.Duration(usage) * time.Microsecond
+			}
+		}
+	}
+}
+
+// remove removes the cgroup once all of its processes have exited
+func (cgroup *jobCgroup) remove() {
+	cgroup.dir.Close()
+	for i := 0; i < 10; i++ {
+		if err := os.Remove(cgroup.path); err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	log.Printf("WARNING: Unable to remove cgroup %s", cgroup.path)
+}
@@ -0,0 +1,181 @@
+package godoit
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock provides the current time and timers to the scheduler, so that tests
+// can control time rather than sleeping.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+	Sleep(d time.Duration)
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// RealClock is the system clock
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (timer realTimer) C() <-chan time.Time {
+	return timer.timer.C
+}
+
+func (timer realTimer) Stop() bool {
+	return timer.timer.Stop()
+}
+
+// FakeClock is a Clock which only moves when it is advanced. Timers fire as
+// the clock is advanced past them.
+type FakeClock struct {
+	lock sync.Mutex
+	rearmed *sync.Cond
+	now time.Time
+	timers []*fakeTimer
+	firing *fakeTimer
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	deadline time.Time
+	c chan time.Time
+	rearms bool
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	clock := &FakeClock{now: now}
+	clock.rearmed = sync.NewCond(&clock.lock)
+	return clock
+}
+
+func (clock *FakeClock) Now() time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	return clock.now
+}
+
+func (clock *FakeClock) After(d time.Duration) <-chan time.Time {
+	return clock.addTimer(d, false).c
+}
+
+func (clock *FakeClock) NewTimer(d time.Duration) Timer {
+	return clock.addTimer(d, true)
+}
+
+func (clock *FakeClock) Sleep(d time.Duration) {
+	<-clock.After(d)
+}
+
+// Advance moves the clock forward firing each timer which is due in deadline
+// order. After firing a timer from NewTimer, Advance waits for the owner to set
+// its next timer or stop the timer, so a scheduler runs every time it is due
+// rather than once and has started the runs which were due when Advance returns.
+func (clock *FakeClock) Advance(d time.Duration) {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	target := clock.now.Add(d)
+	for timer := clock.removeDue(target); timer != nil; timer = clock.removeDue(target) {
+		if timer.deadline.After(clock.now) {
+			clock.now = timer.deadline
+		}
+		timer.c <- clock.now
+		if timer.rearms {
+			clock.firing = timer
+			for clock.firing == timer {
+				clock.rearmed.Wait()
+			}
+		}
+	}
+	clock.now = target
+}
+
+// Timers returns the number of timers waiting to fire
+func (clock *FakeClock) Timers() int {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	return len(clock.timers)
+}
+
+// rearm lets Advance continue once the owner of the timer it fired has set its
+// next timer or stopped the timer
+func (clock *FakeClock) rearm() {
+	if clock.firing != nil {
+		clock.firing = nil
+		clock.rearmed.Broadcast()
+	}
+}
+
+func (clock *FakeClock) addTimer(d time.Duration, rearms bool) *fakeTimer {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	timer := &fakeTimer{clock, clock.now.Add(d), make(chan time.Time, 1), rearms}
+	if rearms {
+		clock.rearm()
+	}
+	if d <= 0 {
+		timer.c <- clock.now
+	} else {
+		clock.timers = append(clock.timers, timer)
+	}
+	return timer
+}
+
+func (clock *FakeClock) removeDue(target time.Time) *fakeTimer {
+	sort.SliceStable(clock.timers, func(i, j int) bool {
+		return clock.timers[i].deadline.Before(clock.timers[j].deadline)
+	})
+	if len(clock.timers) == 0 || clock.timers[0].deadline.After(target) {
+		return nil
+	}
+	timer := clock.timers[0]
+	clock.timers = clock.timers[1:]
+	return timer
+}
+
+func (timer *fakeTimer) C() <-chan time.Time {
+	return timer.c
+}
+
+func (timer *fakeTimer) Stop() bool {
+	clock := timer.clock
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	if clock.firing == timer {
+		clock.rearm()
+	}
+	for i, t := range clock.timers {
+		if t == timer {
+			clock.timers = append(clock.timers[:i], clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package godoit
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"time"
+)
+
+func TestFakeClockFiresTimersInOrder(t *testing.T) {
+	clock := NewFakeClock(testStartTime)
+	later := clock.After(2 * time.Second)
+	sooner := clock.After(time.Second)
+	assert.Equal(t, 2, clock.Timers())
+
+	clock.Advance(500 * time.Millisecond)
+	assert.Equal(t, testStartTime.Add(500 * time.Millisecond), clock.Now())
+	assert.Equal(t, 0, len(sooner))
+
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, testStartTime.Add(time.Second), <-sooner)
+	assert.Equal(t, testStartTime.Add(2 * time.Second), <-later)
+	assert.Equal(t, testStartTime.Add(2500 * time.Millisecond), clock.Now())
+	assert.Equal(t, 0, clock.Timers())
+}
+
+func TestFakeClockStopTimer(t *testing.T) {
+	clock := NewFakeClock(testStartTime)
+	timer := clock.NewTimer(time.Second)
+	assert.True(t, timer.Stop())
+	assert.False(t, timer.Stop())
+	clock.Advance(time.Second)
+	assert.Equal(t, 0, len(timer.C()))
+}
+
+func TestFakeClockWaitsForRearm(t *testing.T) {
+	clock := NewFakeClock(testStartTime)
+	ticks := make(chan time.Time, 10)
+	timer := clock.NewTimer(time.Second)
+	go func() {
+		for i := 0; i < 3; i++ {
+			now := <-timer.C()
+			ticks <- now
+			timer = clock.NewTimer(time.Second)
+		}
+	}()
+
+	clock.Advance(3 * time.Second)
+	assert.Equal(t, 3, len(ticks))
+	assert.Equal(t, testStartTime.Add(time.Second), <-ticks)
+	assert.Equal(t, testStartTime.Add(2 * time.Second), <-ticks)
+	assert.Equal(t, testStartTime.Add(3 * time.Second), <-ticks)
+}
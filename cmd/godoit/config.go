@@ -0,0 +1,621 @@
+package main
+
+import (
+	"github.com/BurntSushi/toml"
+	"github.com/timjwright/godoit"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+)
+
+
+type GoDoItConfig struct {
+	Include []string `toml:"include" doc:"Paths to scan"`
+	Exclude []string `toml:"Exclude" doc:"Directories and job files not to scan"`
+	Recursive bool `toml:"Recursive" doc:"Scan the directories below each included directory"`
+	MaxDepth int `toml:"MaxDepth" doc:"Levels below a directory ** and recursive scanning look, 0 for no limit"`
+	IncludeConfigDir string `toml:"IncludeConfigDir" doc:"Directory of *.conf files merged into the configuration"`
+	JobExecutorScript string`toml:"JobExecutorScript" doc:"Paths for job executor script"`
+	JobExecutor string `toml:"JobExecutor" doc:"Default executor for jobs: script, exec or http"`
+	JobExecutorUrl string `toml:"JobExecutorUrl" doc:"URL the http executor posts jobs to"`
+	ScanTime int `toml:"ScanTime" doc:"Scan time in seconds"`
+	LogFile string `toml:"LogFile" doc:"Logfile location"`
+	StateFile string `toml:"StateFile" doc:"File recording the @once jobs which have run and the runs of jobs with maxruns"`
+	LogMaxSize int `toml:"LogMaxSize" doc:"Log fie max size"`
+	LogMaxAge int `toml:"LogMaxAge" doc:"Number of days to keep th log file"`
+	LogMaxBackups int `toml:"LogMaxBackups" doc:"Number of backup log files to keep"`
+	StatusScript string`toml:"StatusScript" doc:"Paths for status reporting script"`
+	StatusInterval int`toml:"StatusInterval" doc:"How often status script is run in seconds"`
+	StatusEnvironment []string `toml:"StatusEnvironment" doc:"Environment variables to include in the JSON"`
+	StatusExcluded bool `toml:"StatusExcluded" doc:"Include the excluded directories and job files in the JSON"`
+	JobUser string `toml:"JobUser" doc:"Default user jobs run as"`
+	JobGroup string `toml:"JobGroup" doc:"Default group jobs run as"`
+	JobRlimitAs string `toml:"JobRlimitAs" doc:"Default address space limit for jobs e.g. 2G"`
+	JobRlimitNofile int `toml:"JobRlimitNofile" doc:"Default open files limit for jobs"`
+	JobRlimitCpu int `toml:"JobRlimitCpu" doc:"Default CPU time limit for jobs in seconds"`
+	JobRlimitCore string `toml:"JobRlimitCore" doc:"Default core file size limit for jobs e.g. 0"`
+	JobNice int `toml:"JobNice" doc:"Default nice value for jobs"`
+	JobIonice string `toml:"JobIonice" doc:"Default I/O scheduling class and level for jobs e.g. 'best-effort 4'"`
+	CgroupParent string `toml:"CgroupParent" doc:"cgroup v2 group under which a group is created for each job run"`
+	MaxConcurrentJobs int `toml:"MaxConcurrentJobs" doc:"Maximum number of jobs running at once, 0 for no limit"`
+	MaxConcurrentJobsPerDirectory int `toml:"MaxConcurrentJobsPerDirectory" doc:"Maximum number of jobs running at once in each directory, 0 for no limit"`
+	CalendarDir string `toml:"CalendarDir" doc:"Directory of <name>.ics and <name>.dates calendars jobs can skip"`
+	Blackout []string `toml:"Blackout" doc:"Windows during which no jobs start e.g. '0 0 2 * * SUN 3h'"`
+}
+
+
+// DefaultConfig returns the configuration used for settings which are not set
+// in the configuration file, the environment or on the command line.
+func DefaultConfig() GoDoItConfig {
+	return GoDoItConfig{
+		Include: []string{},
+		Exclude: []string{},
+		ScanTime: 30,
+		LogFile: "godoit.log",
+		StateFile: "godoit.state",
+		LogMaxSize: 100,
+		LogMaxAge: 14,
+		LogMaxBackups: 20,
+		StatusInterval: 60,
+		StatusEnvironment: []string{},
+		Blackout: []string{}}
+}
+
+// ConfigSources records where each configuration value came from, keyed by
+// the configuration key
+type ConfigSources map[string]string
+
+// configField is a setting of GoDoItConfig with the names it is set by
+type configField struct {
+	key string
+	env string
+	value reflect.Value
+}
+
+// configFields returns the settings of the configuration in order
+func configFields(config *GoDoItConfig) []configField {
+	value := reflect.ValueOf(config).Elem()
+	fields := make([]configField, value.NumField())
+	for i := range fields {
+		field := value.Type().Field(i)
+		key := field.Tag.Get("toml")
+		fields[i] = configField{
+			strings.ToLower(key[:1]) + key[1:],
+			"GODOIT_" + upperSnakeCase(field.Name),
+			value.Field(i)}
+	}
+	return fields
+}
+
+func upperSnakeCase(name string) string {
+	snake := ""
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) && !unicode.IsUpper(rune(name[i-1])) {
+			snake += "_"
+		}
+		snake += string(unicode.ToUpper(r))
+	}
+	return snake
+}
+
+// set sets the field from a flag or environment variable, lists are comma
+// separated
+func (field configField) set(value string) error {
+	switch field.value.Kind() {
+	case reflect.Int:
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("Invalid %s: '%s'", field.key, value)
+		}
+		field.value.SetInt(int64(number))
+	case reflect.Bool:
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("Invalid %s: '%s'", field.key, value)
+		}
+		field.value.SetBool(enabled)
+	case reflect.Slice:
+		list := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.value.Set(reflect.ValueOf(list))
+	default:
+		field.value.SetString(value)
+	}
+	return nil
+}
+
+// text returns the value as a job parameter value
+func (field configField) text() string {
+	if field.value.Kind() == reflect.Int {
+		return strconv.FormatInt(field.value.Int(), 10)
+	}
+	return field.value.String()
+}
+
+// String returns the value as TOML
+func (field configField) String() string {
+	switch field.value.Kind() {
+	case reflect.Int:
+		return strconv.FormatInt(field.value.Int(), 10)
+	case reflect.Bool:
+		return strconv.FormatBool(field.value.Bool())
+	case reflect.Slice:
+		items := make([]string, field.value.Len())
+		for i := range items {
+			items[i] = "'" + field.value.Index(i).String() + "'"
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	default:
+		return "'" + field.value.String() + "'"
+	}
+}
+
+// ReadConfig reads the configuration file, applying the defaults for any
+// settings which are not in the file and the GODOIT_* environment variables.
+// If the configuration can be read but has problems the configuration is
+// returned with a ConfigError listing them.
+func ReadConfig(cfgFile string) (*GoDoItConfig, error) {
+	config, sources, problems, err := readConfig(cfgFile)
+	if err != nil {
+		return nil, err
+	}
+	return config, checkConfig(cfgFile, config, sources, problems)
+}
+
+// readConfig reads the configuration, returning problems with keys and values
+// which are skipped and an error if a file cannot be read.
+func readConfig(cfgFile string) (*GoDoItConfig, ConfigSources, []string, error) {
+	goDoItConfig := DefaultConfig()
+	sources := make(ConfigSources)
+	fields := configFields(&goDoItConfig)
+	for _, field := range fields {
+		sources[field.key] = "default"
+	}
+
+	problems := []string{}
+	if cfgFile != "" {
+		keys, unknown, err := decodeConfigFile(cfgFile, &goDoItConfig)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for _, key := range keys {
+			sources[key] = "file " + cfgFile
+		}
+		problems = append(problems, unknown...)
+	}
+
+	if configDir := includeConfigDir(cfgFile, &goDoItConfig); configDir != "" {
+		unknown, err := mergeConfigDir(configDir, &goDoItConfig, sources)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		problems = append(problems, unknown...)
+	}
+
+	for _, field := range fields {
+		if value, ok := os.LookupEnv(field.env); ok {
+			if err := field.set(value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s", field.env, err))
+				continue
+			}
+			sources[field.key] = "env " + field.env
+		}
+	}
+	return &goDoItConfig, sources, problems, nil
+}
+
+// decodeConfigFile decodes the configuration file into the configuration,
+// returning the keys which were set and problems with any unknown keys
+func decodeConfigFile(cfgFile string, config *GoDoItConfig) ([]string, []string, error) {
+	metadata, err := toml.DecodeFile(cfgFile, config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error loading configuration: %s", err.Error())
+	}
+	keys := []string{}
+	for _, key := range metadata.Keys() {
+		for _, field := range configFields(config) {
+			if strings.EqualFold(key.String(), field.key) {
+				keys = append(keys, field.key)
+			}
+		}
+	}
+	unknown := []string{}
+	for _, key := range metadata.Undecoded() {
+		unknown = append(unknown, fmt.Sprintf("%s: Unknown key '%s'", keyLocation(cfgFile, key.String()), key))
+	}
+	return keys, unknown, nil
+}
+
+// resolveConfigDir returns the includeConfigDir, relative to the directory of
+// the configuration file if it is not absolute
+func resolveConfigDir(cfgFile, configDir string) string {
+	configDir = os.ExpandEnv(configDir)
+	if !filepath.IsAbs(configDir) && cfgFile != "" {
+		configDir = filepath.Join(filepath.Dir(cfgFile), configDir)
+	}
+	return configDir
+}
+
+// includeConfigDir returns the includeConfigDir set in the configuration or
+// by GODOIT_INCLUDE_CONFIG_DIR, resolved against the configuration file, or an
+// empty string if there is none
+func includeConfigDir(cfgFile string, config *GoDoItConfig) string {
+	configDir := config.IncludeConfigDir
+	if value, ok := os.LookupEnv("GODOIT_INCLUDE_CONFIG_DIR"); ok {
+		configDir = value
+	}
+	if configDir == "" {
+		return ""
+	}
+	return resolveConfigDir(cfgFile, configDir)
+}
+
+// configVersion identifies the configuration file and the files in its
+// includeConfigDir, it changes when a file is added, removed or modified.
+func configVersion(cfgFile string, config *GoDoItConfig) string {
+	files := []string{cfgFile}
+	if configDir := includeConfigDir(cfgFile, config); configDir != "" {
+		fragments, _ := filepath.Glob(filepath.Join(configDir, "*.conf"))
+		sort.Strings(fragments)
+		files = append(files, fragments...)
+	}
+	return filesVersion(files)
+}
+
+// filesVersion identifies the files by their names, modification times and
+// sizes, skipping any which do not exist
+func filesVersion(files []string) string {
+	version := ""
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			version += fmt.Sprintf("%s %s %d\n", file, info.ModTime(), info.Size())
+		}
+	}
+	return version
+}
+
+// configReloader applies the settings which can change while godoit runs
+type configReloader interface {
+	SetInclude(include []string)
+	SetExclude(exclude []string)
+	SetBlackouts(blackouts []godoit.BlackoutWindow)
+}
+
+// reloadConfig applies the settings which changed in the reloaded
+// configuration and can change while godoit runs, logging a warning for each
+// other setting which changed as it only applies after a restart. It returns
+// the configuration in use.
+func reloadConfig(reloader configReloader, current, reloaded *GoDoItConfig) *GoDoItConfig {
+	applied := *current
+	reloadedFields := configFields(reloaded)
+	for i, field := range configFields(&applied) {
+		value := reloadedFields[i].value
+		if reflect.DeepEqual(field.value.Interface(), value.Interface()) {
+			continue
+		}
+		switch field.key {
+		case "include":
+			reloader.SetInclude(reloaded.Include)
+		case "exclude":
+			reloader.SetExclude(reloaded.Exclude)
+		case "blackout":
+			reloader.SetBlackouts(reloaded.Blackouts())
+		case "includeConfigDir":
+			// The files in the new directory were merged as it was loaded
+		case "calendarDir":
+			// The calendars in the new directory are loaded before each scan
+		default:
+			log.Printf("WARNING: %s changed to %s, restart godoit to apply it", field.key, reloadedFields[i])
+			continue
+		}
+		log.Printf("Reloaded configuration, %s = %s", field.key, reloadedFields[i])
+		field.value.Set(value)
+	}
+	return &applied
+}
+
+// mergeConfigDir merges the *.conf files in the directory into the
+// configuration in filename order. Lists are appended to, other settings are
+// replaced with a warning if a file changes a value set by another file.
+func mergeConfigDir(configDir string, config *GoDoItConfig, sources ConfigSources) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(configDir, "*.conf"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid includeConfigDir: '%s'", configDir)
+	}
+	sort.Strings(files)
+
+	fields := make(map[string]configField)
+	for _, field := range configFields(config) {
+		fields[field.key] = field
+	}
+	problems := []string{}
+	for _, file := range files {
+		var fragment GoDoItConfig
+		keys, unknown, err := decodeConfigFile(file, &fragment)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		problems = append(problems, unknown...)
+		fragmentFields := make(map[string]configField)
+		for _, field := range configFields(&fragment) {
+			fragmentFields[field.key] = field
+		}
+		for _, key := range keys {
+			if key == "includeConfigDir" {
+				log.Printf("WARNING: includeConfigDir is ignored in %s", file)
+				continue
+			}
+			field, value := fields[key], fragmentFields[key].value
+			source := "file " + file
+			if field.value.Kind() == reflect.Slice {
+				field.value.Set(reflect.AppendSlice(field.value, value))
+				if sources[key] != "default" {
+					source = sources[key] + ", " + file
+				}
+			} else {
+				if sources[key] != "default" && !reflect.DeepEqual(field.value.Interface(), value.Interface()) {
+					log.Printf(
+						"WARNING: %s in %s overrides %s from %s",
+						key,
+						file,
+						field,
+						strings.TrimPrefix(sources[key], "file "))
+				}
+				field.value.Set(value)
+			}
+			sources[key] = source
+		}
+	}
+	return problems, nil
+}
+
+// configFlags are the command line flags which choose the configuration file
+// and override settings from it
+type configFlags struct {
+	flags *flag.FlagSet
+	file *string
+	logFile *string
+	scanTime *int
+	include stringList
+}
+
+func newConfigFlags(flags *flag.FlagSet) *configFlags {
+	configFlags := &configFlags{flags: flags}
+	configFlags.file = flags.String("config", "", "Configuration file")
+	configFlags.logFile = flags.String("log-file", "", "Log file, overrides logFile")
+	configFlags.scanTime = flags.Int("scan-time", 0, "Scan period in seconds, overrides scanTime")
+	flags.Var(&configFlags.include, "include", "Pattern of directories to scan for jobs, may be repeated, overrides include")
+	return configFlags
+}
+
+// load reads the configuration file given by -config, or as the only argument,
+// and applies the flags which were set. If the configuration has problems it
+// is returned with a ConfigError listing them.
+func (configFlags *configFlags) load() (*GoDoItConfig, ConfigSources, error) {
+	cfgFile := configFlags.configFile()
+	if args := configFlags.flags.Args(); len(args) > 0 && cfgFile != args[0] {
+		return nil, nil, fmt.Errorf("Unexpected arguments: %s", strings.Join(args, " "))
+	}
+
+	goDoItConfig, sources, problems, err := readConfig(cfgFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	overrides := map[string]string{"log-file": "logFile", "scan-time": "scanTime", "include": "include"}
+	fields := make(map[string]configField)
+	for _, field := range configFields(goDoItConfig) {
+		fields[field.key] = field
+	}
+	configFlags.flags.Visit(func(f *flag.Flag) {
+		key, ok := overrides[f.Name]
+		if !ok {
+			return
+		}
+		if key == "include" {
+			// Each -include is a whole pattern, which may contain commas
+			fields[key].value.Set(reflect.ValueOf(append([]string{}, configFlags.include...)))
+		} else if err := fields[key].set(f.Value.String()); err != nil {
+			problems = append(problems, fmt.Sprintf("-%s: %s", f.Name, err))
+			return
+		}
+		sources[key] = "flag -" + f.Name
+	})
+	return goDoItConfig, sources, checkConfig(cfgFile, goDoItConfig, sources, problems)
+}
+
+// configFile returns the configuration file given by -config or as the only
+// argument
+func (configFlags *configFlags) configFile() string {
+	args := configFlags.flags.Args()
+	if *configFlags.file == "" && len(args) == 1 {
+		return args[0]
+	}
+	return *configFlags.file
+}
+
+// PrintConfig writes the configuration as TOML with where each value came from
+func PrintConfig(config *GoDoItConfig, sources ConfigSources, output io.Writer) {
+	writer := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	for _, field := range configFields(config) {
+		fmt.Fprintf(writer, "%s = %s\t# %s\n", field.key, field, sources[field.key])
+	}
+	writer.Flush()
+}
+
+// ConfigCommand runs the config subcommands, `config print` writes the
+// effective configuration.
+func ConfigCommand(args []string, output io.Writer) int {
+	flags := flag.NewFlagSet("config print", flag.ContinueOnError)
+	flags.SetOutput(output)
+	configFlags := newConfigFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(output, "Usage: %s config print [-config <config file>] [-log-file <file>] [-scan-time <seconds>] [-include <pattern>]...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "print" {
+		flags.Usage()
+		return 2
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	config, sources, err := configFlags.load()
+	if config == nil {
+		fmt.Fprintln(output, err)
+		return 1
+	}
+	PrintConfig(config, sources, output)
+	if err != nil {
+		fmt.Fprintln(output, err)
+		return 1
+	}
+	return 0
+}
+
+// jobDefaultParams maps the configuration keys which set job defaults to the
+// job parameter they set
+var jobDefaultParams = map[string]string{
+	"jobUser": "user",
+	"jobGroup": "group",
+	"jobRlimitAs": "rlimit-as",
+	"jobRlimitNofile": "rlimit-nofile",
+	"jobRlimitCpu": "rlimit-cpu",
+	"jobRlimitCore": "rlimit-core",
+	"jobNice": "nice",
+	"jobIonice": "ionice",
+	"jobExecutor": "executor",
+}
+
+// JobDefaults returns the job parameters set in the configuration which apply
+// to every job unless overridden in the job file.
+func (config *GoDoItConfig) JobDefaults() godoit.JobDefaults {
+	defaults := make(godoit.JobDefaults)
+	for _, field := range configFields(config) {
+		if param, ok := jobDefaultParams[field.key]; ok && !field.value.IsZero() {
+			defaults[param] = field.text()
+		}
+	}
+	return defaults
+}
+
+// DefaultExecutor returns the name of the executor for jobs which do not name one
+func (config *GoDoItConfig) DefaultExecutor() string {
+	if config.JobExecutor == "" {
+		return godoit.DefaultExecutor
+	}
+	return config.JobExecutor
+}
+
+// ExecutorOptions returns the settings executors are created from
+func (config *GoDoItConfig) ExecutorOptions(output io.Writer) godoit.ExecutorOptions {
+	return godoit.ExecutorOptions{
+		Script: config.JobExecutorScript,
+		URL: config.JobExecutorUrl,
+		CgroupParent: config.CgroupParent,
+		Output: output,
+		Clock: godoit.RealClock}
+}
+
+// ScannerOptions returns the options for a scanner of the included directories
+// which runs each job with the executor it names.
+func (config *GoDoItConfig) ScannerOptions(output io.Writer) godoit.ScannerOptions {
+	return godoit.ScannerOptions{
+		Include: config.Include,
+		Exclude: config.Exclude,
+		Recursive: config.Recursive,
+		MaxDepth: config.MaxDepth,
+		Defaults: config.JobDefaults(),
+		Executor: godoit.SelectExecutor(config.DefaultExecutor(), config.ExecutorOptions(output)),
+		MaxConcurrentJobs: config.MaxConcurrentJobs,
+		MaxConcurrentJobsPerDirectory: config.MaxConcurrentJobsPerDirectory,
+		StateFile: config.StateFile,
+		Blackouts: config.Blackouts(),
+		StatusExcluded: config.StatusExcluded}
+}
+
+// Blackouts returns the blackout windows, skipping any which are invalid
+func (config *GoDoItConfig) Blackouts() []godoit.BlackoutWindow {
+	blackouts := []godoit.BlackoutWindow{}
+	for _, value := range config.Blackout {
+		if blackout, err := godoit.ParseBlackoutWindow(value); err == nil {
+			blackouts = append(blackouts, blackout)
+		}
+	}
+	return blackouts
+}
+
+// LoadCalendars registers the calendars in the calendarDir, which is relative
+// to the directory of the configuration file if it is not absolute
+func (config *GoDoItConfig) LoadCalendars(cfgFile string) error {
+	if config.CalendarDir == "" {
+		return nil
+	}
+	_, err := godoit.LoadCalendars(config.calendarDir(cfgFile))
+	return err
+}
+
+// calendarDir returns the calendarDir resolved against the configuration file,
+// or an empty string if there is none
+func (config *GoDoItConfig) calendarDir(cfgFile string) string {
+	if config.CalendarDir == "" {
+		return ""
+	}
+	return resolveConfigDir(cfgFile, config.CalendarDir)
+}
+
+// calendarLoader registers the calendars in a calendarDir, loading them again
+// when a file in the directory is added, changed or removed and unregistering
+// the calendars whose files were removed
+type calendarLoader struct {
+	directory string
+	version string
+	names []string
+}
+
+// load loads the calendars in the directory if it or its files changed since
+// they were last loaded. The calendars loaded before are kept if a file is
+// invalid, and the error is only returned once for each change.
+func (loader *calendarLoader) load(directory string) error {
+	version := ""
+	if directory != "" {
+		files, _ := filepath.Glob(filepath.Join(directory, "*"))
+		version = filesVersion(append([]string{directory}, files...))
+	}
+	if directory == loader.directory && version == loader.version {
+		return nil
+	}
+	loader.directory, loader.version = directory, version
+
+	names := []string{}
+	if directory != "" {
+		loaded, err := godoit.LoadCalendars(directory)
+		if err != nil {
+			return err
+		}
+		names = loaded
+	}
+	loaded := make(map[string]bool)
+	for _, name := range names {
+		loaded[name] = true
+	}
+	for _, name := range loader.names {
+		if !loaded[name] {
+			godoit.UnregisterCalendar(name)
+		}
+	}
+	loader.names = names
+	return nil
+}
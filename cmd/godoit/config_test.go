@@ -0,0 +1,273 @@
+package main
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"bytes"
+	"flag"
+	"log"
+	"os"
+	"path"
+	"github.com/timjwright/godoit"
+)
+
+func TestReadConfigDefaults(t *testing.T) {
+	withDir(func(dir string) {
+		cfgFile := path.Join(dir, "godoit.conf")
+		writeFile(cfgFile, "include = [ '/opt/*' ]", "jobExecutorScript = 'wrapper.sh'")
+
+		config, sources, _, err := readConfig(cfgFile)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"/opt/*"}, config.Include)
+		assert.Equal(t, 30, config.ScanTime)
+		assert.Equal(t, "godoit.log", config.LogFile)
+		assert.Equal(t, "file " + cfgFile, sources["include"])
+		assert.Equal(t, "file " + cfgFile, sources["jobExecutorScript"])
+		assert.Equal(t, "default", sources["scanTime"])
+	})
+}
+
+func TestEnvironmentOverridesConfig(t *testing.T) {
+	withDir(func(dir string) {
+		cfgFile := path.Join(dir, "godoit.conf")
+		writeFile(cfgFile, "scanTime = 10", "include = [ '/opt/*' ]")
+		os.Setenv("GODOIT_SCAN_TIME", "20")
+		os.Setenv("GODOIT_STATUS_ENVIRONMENT", "REGION, ROLE")
+		os.Setenv("GODOIT_MAX_CONCURRENT_JOBS_PER_DIRECTORY", "2")
+		defer os.Unsetenv("GODOIT_SCAN_TIME")
+		defer os.Unsetenv("GODOIT_STATUS_ENVIRONMENT")
+		defer os.Unsetenv("GODOIT_MAX_CONCURRENT_JOBS_PER_DIRECTORY")
+
+		config, sources, _, err := readConfig(cfgFile)
+		assert.Nil(t, err)
+		assert.Equal(t, 20, config.ScanTime)
+		assert.Equal(t, []string{"REGION", "ROLE"}, config.StatusEnvironment)
+		assert.Equal(t, 2, config.MaxConcurrentJobsPerDirectory)
+		assert.Equal(t, "env GODOIT_SCAN_TIME", sources["scanTime"])
+
+		os.Setenv("GODOIT_SCAN_TIME", "often")
+		_, _, problems, _ := readConfig(cfgFile)
+		assert.Equal(t, []string{"GODOIT_SCAN_TIME: Invalid scanTime: 'often'"}, problems)
+	})
+}
+
+func TestFlagsOverrideConfig(t *testing.T) {
+	withDir(func(dir string) {
+		cfgFile := path.Join(dir, "godoit.conf")
+		writeFile(cfgFile, "scanTime = 10", "include = [ '/opt/*' ]", "jobExecutorScript = '../../test_wrapper.sh'", "statusInterval = 0")
+		os.Setenv("GODOIT_SCAN_TIME", "20")
+		defer os.Unsetenv("GODOIT_SCAN_TIME")
+
+		flags := flag.NewFlagSet("godoit", flag.ContinueOnError)
+		configFlags := newConfigFlags(flags)
+		flags.Parse([]string{"-config", cfgFile, "-scan-time", "5", "-include", "/a/*", "-include", "/b,c"})
+		config, sources, err := configFlags.load()
+		assert.Nil(t, err)
+		assert.Equal(t, 5, config.ScanTime)
+		assert.Equal(t, []string{"/a/*", "/b,c"}, config.Include)
+		assert.Equal(t, "flag -scan-time", sources["scanTime"])
+		assert.Equal(t, "flag -include", sources["include"])
+
+		// The configuration file can be the only argument
+		flags = flag.NewFlagSet("godoit", flag.ContinueOnError)
+		configFlags = newConfigFlags(flags)
+		flags.Parse([]string{cfgFile})
+		config, _, err = configFlags.load()
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"/opt/*"}, config.Include)
+	})
+}
+
+func TestConfigPrint(t *testing.T) {
+	withDir(func(dir string) {
+		cfgFile := path.Join(dir, "godoit.conf")
+		writeFile(cfgFile, "logFile = '/var/log/godoit.log'", "jobExecutorScript = '../../test_wrapper.sh'", "statusInterval = 0")
+
+		output := new(bytes.Buffer)
+		assert.Equal(t, 0, ConfigCommand([]string{"print", "-config", cfgFile, "-scan-time", "5"}, output))
+		assert.Contains(t, output.String(), "include = []")
+		assert.Regexp(t, "scanTime = 5 +# flag -scan-time\n", output.String())
+		assert.Regexp(t, "logFile = '/var/log/godoit.log' +# file " + cfgFile + "\n", output.String())
+		assert.Regexp(t, "logMaxSize = 100 +# default\n", output.String())
+
+		assert.Equal(t, 2, ConfigCommand([]string{"show"}, new(bytes.Buffer)))
+	})
+}
+
+func TestIncludeConfigDirIsMerged(t *testing.T) {
+	withDir(func(dir string) {
+		cfgFile := path.Join(dir, "godoit.conf")
+		writeFile(cfgFile, "include = [ '/opt/*' ]", "includeConfigDir = 'conf.d'", "scanTime = 10")
+		os.Mkdir(path.Join(dir, "conf.d"), 0755)
+		writeFile(path.Join(dir, "conf.d", "b.conf"), "include = [ '/srv/b/*' ]", "scanTime = 20")
+		writeFile(path.Join(dir, "conf.d", "a.conf"), "include = [ '/srv/a/*' ]", "statusEnvironment = [ 'REGION' ]")
+		writeFile(path.Join(dir, "conf.d", "notes.txt"), "include = [ '/ignored' ]")
+
+		logged := new(bytes.Buffer)
+		log.SetOutput(logged)
+		defer log.SetOutput(os.Stderr)
+		config, sources, _, err := readConfig(cfgFile)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"/opt/*", "/srv/a/*", "/srv/b/*"}, config.Include)
+		assert.Equal(t, []string{"REGION"}, config.StatusEnvironment)
+		assert.Equal(t, 20, config.ScanTime)
+		assert.Equal(t, "file " + dir + "/conf.d/b.conf", sources["scanTime"])
+		assert.Equal(
+			t,
+			"file " + cfgFile + ", " + dir + "/conf.d/a.conf, " + dir + "/conf.d/b.conf",
+			sources["include"])
+		assert.Contains(t, logged.String(), "WARNING: scanTime in " + dir + "/conf.d/b.conf overrides 10 from " + cfgFile)
+	})
+}
+
+func TestConfigVersionChangesWithConfigDir(t *testing.T) {
+	withDir(func(dir string) {
+		cfgFile := path.Join(dir, "godoit.conf")
+		writeFile(cfgFile, "includeConfigDir = 'conf.d'")
+		os.Mkdir(path.Join(dir, "conf.d"), 0755)
+		config, _, _, _ := readConfig(cfgFile)
+		version := configVersion(cfgFile, config)
+
+		writeFile(path.Join(dir, "conf.d", "a.conf"), "include = [ '/srv/a/*' ]")
+		added := configVersion(cfgFile, config)
+		assert.NotEqual(t, version, added)
+
+		os.Remove(path.Join(dir, "conf.d", "a.conf"))
+		assert.Equal(t, version, configVersion(cfgFile, config))
+	})
+}
+
+func TestConfigVersionUsesConfigDirFromEnvironment(t *testing.T) {
+	withDir(func(dir string) {
+		cfgFile := path.Join(dir, "godoit.conf")
+		writeFile(cfgFile, "scanTime = 10")
+		os.Mkdir(path.Join(dir, "conf.d"), 0755)
+		os.Setenv("GODOIT_INCLUDE_CONFIG_DIR", "conf.d")
+		defer os.Unsetenv("GODOIT_INCLUDE_CONFIG_DIR")
+		config := DefaultConfig()
+		version := configVersion(cfgFile, &config)
+
+		writeFile(path.Join(dir, "conf.d", "a.conf"), "include = [ '/srv/a/*' ]")
+		assert.NotEqual(t, version, configVersion(cfgFile, &config))
+	})
+}
+
+// testReloader records the settings applied by reloadConfig
+type testReloader struct {
+	include []string
+	exclude []string
+	blackouts []godoit.BlackoutWindow
+}
+
+func (reloader *testReloader) SetInclude(include []string) {
+	reloader.include = include
+}
+
+func (reloader *testReloader) SetExclude(exclude []string) {
+	reloader.exclude = exclude
+}
+
+func (reloader *testReloader) SetBlackouts(blackouts []godoit.BlackoutWindow) {
+	reloader.blackouts = blackouts
+}
+
+func TestReloadConfigAppliesReloadableSettings(t *testing.T) {
+	logged := new(bytes.Buffer)
+	log.SetOutput(logged)
+	defer log.SetOutput(os.Stderr)
+
+	current := DefaultConfig()
+	reloaded := DefaultConfig()
+	reloaded.Include = []string{"/srv/*"}
+	reloaded.Blackout = []string{"0 0 2 * * SUN 3h"}
+	reloaded.ScanTime = 10
+	reloader := &testReloader{}
+	applied := reloadConfig(reloader, &current, &reloaded)
+
+	assert.Equal(t, []string{"/srv/*"}, reloader.include)
+	assert.Nil(t, reloader.exclude)
+	assert.Equal(t, 1, len(reloader.blackouts))
+	assert.Equal(t, []string{"/srv/*"}, applied.Include)
+	assert.Equal(t, 30, applied.ScanTime)
+	assert.Contains(t, logged.String(), "WARNING: scanTime changed to 10, restart godoit to apply it")
+	assert.Equal(t, 30, current.ScanTime)
+	assert.Equal(t, []string{}, current.Include)
+}
+
+func TestConfigProblemsAreReportedTogether(t *testing.T) {
+	withDir(func(dir string) {
+		cfgFile := path.Join(dir, "godoit.conf")
+		writeFile(
+			cfgFile,
+			"include = [ '/opt/[' ]",
+			"jobExecutorScript = '../../test_wrapper.sh'",
+			"scanTim = 10",
+			"scanTime = 0",
+			"statusInterval = 0",
+			"maxConcurrentJobs = -1")
+		os.Setenv("GODOIT_LOG_MAX_AGE", "forever")
+		defer os.Unsetenv("GODOIT_LOG_MAX_AGE")
+
+		config, err := ReadConfig(cfgFile)
+		assert.NotNil(t, config)
+		assert.Equal(
+			t,
+			[]string{
+				cfgFile + ":3: Unknown key 'scanTim'",
+				"GODOIT_LOG_MAX_AGE: Invalid logMaxAge: 'forever'",
+				cfgFile + ":4: scanTime must be at least 1, not 0",
+				cfgFile + ":6: maxConcurrentJobs must be at least 0, not -1",
+				cfgFile + ":1: Invalid include pattern: '/opt/['"},
+			err.(*ConfigError).Problems)
+	})
+}
+
+func TestCalendarsAndBlackoutsAreChecked(t *testing.T) {
+	withDir(func(dir string) {
+		cfgFile := path.Join(dir, "godoit.conf")
+		writeFile(
+			cfgFile,
+			"jobExecutorScript = '../../test_wrapper.sh'",
+			"statusInterval = 0",
+			"calendarDir = 'calendars'",
+			"blackout = [ '0 0 2 * * SUN Europe/London 3h', '0 0 2 * * SUN' ]")
+		os.Mkdir(path.Join(dir, "calendars"), 0755)
+		writeFile(path.Join(dir, "calendars", "holidays.dates"), "2017-12-25")
+
+		config, err := ReadConfig(cfgFile)
+		assert.Equal(t, []string{cfgFile + ":4: Invalid blackout: '0 0 2 * * SUN'"}, err.(*ConfigError).Problems)
+		assert.False(t, godoit.IsCalendar("holidays"))
+		assert.Equal(t, 1, len(config.ScannerOptions(nil).Blackouts))
+
+		writeFile(path.Join(dir, "calendars", "holidays.dates"), "Christmas")
+		_, err = ReadConfig(cfgFile)
+		assert.Contains(t, err.(*ConfigError).Problems[0], cfgFile + ":3: Invalid date 'Christmas'")
+	})
+}
+
+func TestCalendarsAreLoadedWhenChanged(t *testing.T) {
+	withDir(func(dir string) {
+		defer godoit.UnregisterCalendar("holidays")
+		defer godoit.UnregisterCalendar("office")
+		writeFile(path.Join(dir, "holidays.dates"), "2017-12-25")
+		loader := &calendarLoader{}
+		assert.Nil(t, loader.load(dir))
+		assert.True(t, godoit.IsCalendar("holidays"))
+
+		// Invalid files are reported once and keep the calendars loaded
+		writeFile(path.Join(dir, "office.dates"), "Christmas")
+		assert.NotNil(t, loader.load(dir))
+		assert.Nil(t, loader.load(dir))
+		assert.True(t, godoit.IsCalendar("holidays"))
+		assert.False(t, godoit.IsCalendar("office"))
+
+		writeFile(path.Join(dir, "office.dates"), "2017-12-27")
+		os.Remove(path.Join(dir, "holidays.dates"))
+		assert.Nil(t, loader.load(dir))
+		assert.False(t, godoit.IsCalendar("holidays"))
+		assert.True(t, godoit.IsCalendar("office"))
+
+		assert.Nil(t, loader.load(""))
+		assert.False(t, godoit.IsCalendar("office"))
+	})
+}
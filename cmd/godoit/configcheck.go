@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"github.com/timjwright/godoit"
+)
+
+// ConfigError lists every problem found in the configuration, each starting
+// with where the value was set
+type ConfigError struct {
+	Problems []string
+}
+
+func (err *ConfigError) Error() string {
+	return strings.Join(err.Problems, "\n")
+}
+
+// checkConfig checks the values in the configuration are usable, returning a
+// ConfigError with these problems and any found reading the configuration.
+// The calendars in the calendarDir are read to check them but not registered.
+func checkConfig(cfgFile string, config *GoDoItConfig, sources ConfigSources, problems []string) error {
+	problem := func(key, format string, args ...interface{}) {
+		problems = append(problems, configLocation(cfgFile, key, sources) + ": " + fmt.Sprintf(format, args...))
+	}
+
+	for _, field := range configFields(config) {
+		if field.value.Kind() == reflect.Int && field.key != "jobNice" {
+			minimum := int64(0)
+			if field.key == "scanTime" {
+				minimum = 1
+			}
+			if field.value.Int() < minimum {
+				problem(field.key, "%s must be at least %d, not %d", field.key, minimum, field.value.Int())
+			}
+		}
+	}
+
+	if config.LogFile == "" {
+		problem("logFile", "logFile is not defined")
+	}
+	for _, pattern := range config.Include {
+		if _, err := filepath.Glob(path.Clean(os.ExpandEnv(pattern))); err != nil {
+			problem("include", "Invalid include pattern: '%s'", pattern)
+		}
+	}
+	for _, pattern := range config.Exclude {
+		if _, err := filepath.Match(os.ExpandEnv(pattern), ""); err != nil {
+			problem("exclude", "Invalid exclude pattern: '%s'", pattern)
+		}
+	}
+	if config.IncludeConfigDir != "" {
+		configDir := resolveConfigDir(cfgFile, config.IncludeConfigDir)
+		if info, err := os.Stat(configDir); err != nil || !info.IsDir() {
+			problem("includeConfigDir", "includeConfigDir '%s' is not a directory", configDir)
+		}
+	}
+	if config.CalendarDir != "" {
+		calendarDir := config.calendarDir(cfgFile)
+		if info, err := os.Stat(calendarDir); err != nil || !info.IsDir() {
+			problem("calendarDir", "calendarDir '%s' is not a directory", calendarDir)
+		} else if _, err := godoit.ReadCalendars(calendarDir); err != nil {
+			problem("calendarDir", "%s", err)
+		}
+	}
+	for _, value := range config.Blackout {
+		if _, err := godoit.ParseBlackoutWindow(value); err != nil {
+			problem("blackout", "%s", err)
+		}
+	}
+
+	scripts := []string{}
+	if config.DefaultExecutor() == "script" || config.JobExecutorScript != "" {
+		scripts = append(scripts, "jobExecutorScript")
+	}
+	if config.StatusInterval > 0 {
+		scripts = append(scripts, "statusScript")
+	}
+	for _, key := range scripts {
+		script := config.JobExecutorScript
+		if key == "statusScript" {
+			script = config.StatusScript
+		}
+		if script == "" {
+			problem(key, "%s is not defined", key)
+		} else if _, err := exec.LookPath(os.ExpandEnv(script)); err != nil {
+			problem(key, "%s '%s' is not an executable file", key, script)
+		}
+	}
+	if config.DefaultExecutor() == "http" || config.JobExecutorUrl != "" {
+		key := "jobExecutorUrl"
+		if config.JobExecutorUrl == "" {
+			key = "jobExecutor"
+		}
+		if _, err := godoit.NewExecutor("http", config.ExecutorOptions(nil)); err != nil {
+			problem(key, "%s", err)
+		}
+	}
+
+	for _, field := range configFields(config) {
+		if param, ok := jobDefaultParams[field.key]; ok && !field.value.IsZero() {
+			for _, jobError := range godoit.CheckJobDefaults(godoit.JobDefaults{param: field.text()}) {
+				problem(field.key, "%s", jobError)
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ConfigError{problems}
+}
+
+// configLocation returns where the key was set, the file and line, the
+// environment variable or the flag
+func configLocation(cfgFile, key string, sources ConfigSources) string {
+	source := sources[key]
+	if strings.HasPrefix(source, "file ") {
+		file := strings.SplitN(strings.TrimPrefix(source, "file "), ", ", 2)[0]
+		return keyLocation(file, key)
+	} else if strings.HasPrefix(source, "env ") {
+		return strings.TrimPrefix(source, "env ")
+	} else if strings.HasPrefix(source, "flag ") {
+		return strings.TrimPrefix(source, "flag ")
+	} else if cfgFile != "" {
+		return cfgFile
+	}
+	return "defaults"
+}
+
+// keyLocation returns the file and the line the key is set on, or just the
+// file if the line cannot be found
+func keyLocation(file, key string) string {
+	f, err := os.Open(file)
+	if err != nil {
+		return file
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		parts := strings.SplitN(scanner.Text(), "=", 2)
+		if len(parts) == 2 && strings.EqualFold(strings.Trim(strings.TrimSpace(parts[0]), `"'`), key) {
+			return fmt.Sprintf("%s:%d", file, line)
+		}
+	}
+	return file
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"github.com/robfig/cron"
+	"github.com/natefinch/lumberjack"
+	"github.com/timjwright/godoit"
+	"os"
+	"os/signal"
+	"log"
+	"fmt"
+	"flag"
+	"io"
+)
+
+// version is set when building releases with -ldflags "-X main.version=..."
+var version = "dev"
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(Validate(os.Args[2:], os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "preview" {
+		os.Exit(Preview(os.Args[2:], os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(ConfigCommand(os.Args[2:], os.Stdout))
+	}
+
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	configFlags := newConfigFlags(flags)
+	foreground := flags.Bool("foreground", false, "Log to stderr rather than the log file")
+	showVersion := flags.Bool("version", false, "Print the version and exit")
+	flags.Parse(os.Args[1:])
+	if *showVersion {
+		fmt.Println("godoit", version)
+		return
+	}
+
+	log.Println("Starting GoDoIt", version)
+	config, sources, err := configFlags.load()
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%s", err)
+	}
+
+	var logger io.Writer = os.Stderr
+	if !*foreground {
+		logger = &lumberjack.Logger{
+			Filename:   os.ExpandEnv(config.LogFile),
+			MaxSize:    config.LogMaxSize, // megabytes
+			MaxAge:     config.LogMaxAge, //days
+			MaxBackups: config.LogMaxBackups, //days
+		}
+	}
+	log.SetOutput(logger)
+	log.Println("Loaded config:")
+	PrintConfig(config, sources, logger)
+	if _, err := godoit.NewExecutor(config.DefaultExecutor(), config.ExecutorOptions(logger)); err != nil {
+		log.Fatal(err.Error())
+	}
+	cfgFile := configFlags.configFile()
+	calendars := &calendarLoader{}
+	if err := calendars.load(config.calendarDir(cfgFile)); err != nil {
+		log.Fatal(err.Error())
+	}
+	scanner, err := godoit.NewScanner(config.ScannerOptions(logger))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	cron := cron.New()
+	// Apply changes to the configuration, for example from files added to or
+	// removed from the includeConfigDir, before each scan. Settings which
+	// cannot change while running are logged until godoit is restarted. The
+	// calendars are loaded again when a file in the calendarDir changes.
+	configSeen := configVersion(cfgFile, config)
+	running := config
+	cron.AddFunc(fmt.Sprintf("@every %ds",config.ScanTime), func(){
+		if current := configVersion(cfgFile, running); current != configSeen {
+			configSeen = current
+			if reloaded, _, err := configFlags.load(); err != nil {
+				log.Printf("ERROR: Failed to reload configuration, %s", err)
+			} else {
+				running = reloadConfig(scanner, running, reloaded)
+			}
+		}
+		if err := calendars.load(running.calendarDir(cfgFile)); err != nil {
+			log.Printf("ERROR: Failed to load calendars, %s", err)
+		}
+		scanner.Run()
+	})
+	log.Println("Starting scanner")
+	cron.Start()
+
+	if config.StatusInterval > 0 {
+		statusReporter, err := godoit.StatusReporterFromScript(config.StatusScript, logger)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		cron.AddFunc(fmt.Sprintf("@every %ds",config.StatusInterval), func(){
+			statusReporter.Report(scanner.Status(config.StatusEnvironment))
+		})
+	}
+
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, os.Kill)
+	s := <- c
+	log.Println("Shutting down: ", s)
+	cron.Stop()
+	scanner.Stop()
+}
+
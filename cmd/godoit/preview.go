@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"github.com/timjwright/godoit"
+)
+
+// previewTimeFormats are the formats accepted for -from and -to, times without
+// a zone are in the local timezone.
+var previewTimeFormats = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// PreviewRun is a run of a job which would occur in the preview window
+type PreviewRun struct {
+	Time string `json:"time"`
+	LocalTime string `json:"localTime"`
+	Timezone string `json:"timezone"`
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Note string `json:"note,omitempty"`
+	time time.Time
+}
+
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+// Preview lists every run of the enabled jobs, found from the configuration
+// include patterns or the given directories, between two times.
+func Preview(args []string, output io.Writer) int {
+	flags := flag.NewFlagSet("preview", flag.ContinueOnError)
+	flags.SetOutput(output)
+	from := flags.String("from", "", "Start of the preview window, defaults to now")
+	to := flags.String("to", "", "End of the preview window, defaults to a day after the start")
+	cfgFile := flags.String("config", "", "Configuration file to find jobs from")
+	var directories stringList
+	flags.Var(&directories, "dir", "Directory of jobs, may be repeated")
+	asJson := flags.Bool("json", false, "Output JSON rather than a table")
+	maxRuns := flags.Int("max", 1000, "Maximum number of runs to list for each job")
+	flags.Usage = func() {
+		fmt.Fprintf(output, "Usage: %s preview [-from <time>] [-to <time>] [-config <config file>] [-dir <dir>]... [-json]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	start, err := parsePreviewTime(*from, time.Now())
+	if err != nil {
+		fmt.Fprintf(output, "Invalid -from: %s\n", err)
+		return 2
+	}
+	end, err := parsePreviewTime(*to, start.Add(24 * time.Hour))
+	if err != nil {
+		fmt.Fprintf(output, "Invalid -to: %s\n", err)
+		return 2
+	}
+
+	// Parsing jobs logs errors which are not relevant to the preview
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	defaults := godoit.JobDefaults{}
+	exclude := []string{}
+	blackouts := []godoit.BlackoutWindow{}
+	if *cfgFile != "" {
+		// Problems such as missing scripts do not affect the preview
+		config, err := ReadConfig(*cfgFile)
+		if config == nil {
+			fmt.Fprintf(output, "%s: %s\n", *cfgFile, err)
+			return 1
+		}
+		config.LoadCalendars(*cfgFile)
+		defaults = config.JobDefaults()
+		exclude = config.Exclude
+		blackouts = config.Blackouts()
+		directories = append(directories, config.ScannerOptions(nil).Directories()...)
+	}
+	if len(directories) == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	jobSets := make([]*godoit.JobSet, 0, len(directories))
+	for _, directory := range directories {
+		jobSet := godoit.NewJobSet(nil, filepath.Clean(directory), defaults, godoit.RealClock)
+		jobSet.SetExclude(exclude)
+		jobSet.SetBlackouts(blackouts)
+		jobSet.ScanJobs()
+		jobSets = append(jobSets, jobSet)
+	}
+
+	runs := previewRuns(jobSets, start, end, *maxRuns)
+	if *asJson {
+		info, _ := json.MarshalIndent(runs, "", "  ")
+		fmt.Fprintln(output, string(info))
+	} else {
+		writer := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "TIME (UTC)\tLOCAL TIME\tTIMEZONE\tJOB\tPATH")
+		for _, run := range runs {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s", run.Time, run.LocalTime, run.Timezone, run.Name, run.Path)
+			if run.Note != "" {
+				fmt.Fprintf(writer, "  (%s)", run.Note)
+			}
+			fmt.Fprintln(writer)
+		}
+		writer.Flush()
+	}
+	return 0
+}
+
+func parsePreviewTime(value string, defaultTime time.Time) (time.Time, error) {
+	if value == "" {
+		return defaultTime, nil
+	}
+	for _, format := range previewTimeFormats {
+		if t, err := time.ParseInLocation(format, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("'%s' is not a time such as 2006-01-02 15:04", value)
+}
+
+// previewRuns returns the runs of the enabled jobs from start up to and
+// including end in time order.
+func previewRuns(jobSets []*godoit.JobSet, start, end time.Time, maxRuns int) []PreviewRun {
+	runs := []PreviewRun{}
+	for _, jobSet := range jobSets {
+		for _, job := range jobSet.Jobs() {
+			// Next returns times after the time given, so start just before the window
+			next, note := jobSet.NextRun(job, start.Add(-time.Nanosecond))
+			limit := maxRuns
+			if job.MaxRuns > 0 && job.MaxRuns < limit {
+				// Runs before the window are not known, so this is the most
+				// the job can run
+				limit = job.MaxRuns
+			}
+			for i := 0; i < limit && !next.IsZero() && !next.After(end); i++ {
+				runs = append(runs, PreviewRun{
+					next.UTC().Format("2006-01-02 15:04:05"),
+					next.Format("2006-01-02 15:04:05 MST"),
+					next.Location().String(),
+					job.Name,
+					job.Filepath,
+					note,
+					next})
+				next, note = jobSet.NextRun(job, next)
+			}
+		}
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		if !runs[i].time.Equal(runs[j].time) {
+			return runs[i].time.Before(runs[j].time)
+		}
+		return runs[i].Path < runs[j].Path
+	})
+	return runs
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"bytes"
+	"encoding/json"
+)
+
+func TestPreviewTable(t *testing.T) {
+	withDir(func(dir string) {
+		createTestJob(dir, "0 0 x x x x hourly.godoit")
+		createTestJob(dir, "daily.godoit", "#:godoit cronspec 0 30 1 * * *", "#:godoit timezone Europe/London")
+		createTestJob(dir, "--0 0 x x x x disabled.godoit")
+
+		output := new(bytes.Buffer)
+		code := Preview([]string{"-dir", dir, "-from", "2017-06-01T00:00:00Z", "-to", "2017-06-01T03:00:00Z"}, output)
+		assert.Equal(t, 0, code)
+		assert.Equal(
+			t,
+			"TIME (UTC)           LOCAL TIME               TIMEZONE       JOB     PATH\n" +
+			"2017-06-01 00:00:00  2017-06-01 00:00:00 UTC  UTC            hourly  " + dir + "/0 0 x x x x hourly.godoit\n" +
+			"2017-06-01 00:30:00  2017-06-01 01:30:00 BST  Europe/London  daily   " + dir + "/daily.godoit\n" +
+			"2017-06-01 01:00:00  2017-06-01 01:00:00 UTC  UTC            hourly  " + dir + "/0 0 x x x x hourly.godoit\n" +
+			"2017-06-01 02:00:00  2017-06-01 02:00:00 UTC  UTC            hourly  " + dir + "/0 0 x x x x hourly.godoit\n" +
+			"2017-06-01 03:00:00  2017-06-01 03:00:00 UTC  UTC            hourly  " + dir + "/0 0 x x x x hourly.godoit\n",
+			output.String())
+	})
+}
+
+func TestPreviewJson(t *testing.T) {
+	withDir(func(dir string) {
+		createTestJob(dir, "daily.godoit", "#:godoit cronspec 0 30 1 * * *", "#:godoit timezone Europe/London")
+
+		output := new(bytes.Buffer)
+		code := Preview([]string{"-dir", dir, "-from", "2017-10-28T00:00:00Z", "-to", "2017-10-31T00:00:00Z", "-json"}, output)
+		assert.Equal(t, 0, code)
+		var runs []PreviewRun
+		assert.Nil(t, json.Unmarshal(output.Bytes(), &runs))
+		assert.Equal(t, 4, len(runs))
+		assert.Equal(t, "2017-10-28 00:30:00", runs[0].Time)
+		// 01:30 occurs twice when the clocks go back
+		assert.Equal(t, "2017-10-29 01:30:00 BST", runs[1].LocalTime)
+		assert.Equal(t, "2017-10-29 01:30:00 GMT", runs[2].LocalTime)
+		assert.Equal(t, "2017-10-30 01:30:00", runs[3].Time)
+		assert.Equal(t, "daily", runs[3].Name)
+	})
+}
+
+func TestPreviewDSTNote(t *testing.T) {
+	withDir(func(dir string) {
+		createTestJob(dir, "daily.godoit", "#:godoit cronspec 0 30 1 * * *", "#:godoit timezone Europe/London", "#:godoit dst once")
+
+		output := new(bytes.Buffer)
+		code := Preview([]string{"-dir", dir, "-from", "2017-10-29T00:00:00Z", "-to", "2017-10-29T12:00:00Z"}, output)
+		assert.Equal(t, 0, code)
+		assert.Equal(
+			t,
+			"TIME (UTC)           LOCAL TIME               TIMEZONE       JOB    PATH\n" +
+			"2017-10-29 00:30:00  2017-10-29 01:30:00 BST  Europe/London  daily  " + dir +
+			"/daily.godoit  (2017-10-29 01:30:00 occurs twice in Europe/London, run once)\n",
+			output.String())
+	})
+}
+
+func TestPreviewInvalidTime(t *testing.T) {
+	output := new(bytes.Buffer)
+	assert.Equal(t, 2, Preview([]string{"-dir", ".", "-from", "tomorrow"}, output))
+	assert.Equal(t, "Invalid -from: 'tomorrow' is not a time such as 2006-01-02 15:04\n", output.String())
+}
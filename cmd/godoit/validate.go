@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"github.com/timjwright/godoit"
+)
+
+// Validate checks job files, directories of job files and optionally the
+// configuration without scheduling anything. Problems are written to the
+// output and the exit code is non-zero if there are any.
+func Validate(args []string, output io.Writer) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	flags.SetOutput(output)
+	cfgFile := flags.String("config", "", "Configuration file to validate")
+	flags.Usage = func() {
+		fmt.Fprintf(output, "Usage: %s validate [-config <config file>] <file-or-dir>...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	paths := flags.Args()
+	if *cfgFile == "" && len(paths) == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	// Parsing jobs logs errors which are reported below
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	problems := 0
+	defaults := godoit.JobDefaults{}
+	if *cfgFile != "" {
+		config, configProblems := validateConfig(*cfgFile, output)
+		problems += configProblems
+		if config != nil {
+			// Problems with the calendars were reported with the configuration
+			config.LoadCalendars(*cfgFile)
+			defaults = config.JobDefaults()
+			if len(paths) == 0 {
+				paths = config.ScannerOptions(nil).Directories()
+			}
+		}
+	}
+
+	jobs := 0
+	for _, jobPath := range paths {
+		checked, jobProblems := validatePath(jobPath, defaults, output)
+		jobs += checked
+		problems += jobProblems
+	}
+
+	fmt.Fprintf(output, "Checked %d jobs, found %d problems\n", jobs, problems)
+	if problems > 0 {
+		return 1
+	}
+	return 0
+}
+
+func validateConfig(cfgFile string, output io.Writer) (*GoDoItConfig, int) {
+	config, err := ReadConfig(cfgFile)
+	if configError, ok := err.(*ConfigError); ok {
+		for _, problem := range configError.Problems {
+			fmt.Fprintln(output, problem)
+		}
+		return config, len(configError.Problems)
+	} else if err != nil {
+		fmt.Fprintf(output, "%s: %s\n", cfgFile, err)
+		return nil, 1
+	}
+	return config, 0
+}
+
+func validatePath(jobPath string, defaults godoit.JobDefaults, output io.Writer) (int, int) {
+	info, err := os.Stat(jobPath)
+	if err != nil {
+		fmt.Fprintf(output, "%s: %s\n", jobPath, err)
+		return 0, 1
+	}
+
+	if !info.IsDir() {
+		return validateJob(filepath.Dir(jobPath), filepath.Base(jobPath), defaults, output)
+	}
+
+	jobSet := godoit.NewJobSet(nil, jobPath, defaults, godoit.RealClock)
+	jobSet.ScanJobs()
+	jobs, problems := 0, 0
+	for _, job := range jobSet.Jobs() {
+		jobs++
+		problems += reportJob(job, output)
+	}
+	return jobs, problems
+}
+
+func validateJob(directory, filename string, defaults godoit.JobDefaults, output io.Writer) (int, int) {
+	problems := 0
+	defaults, err := godoit.DirectoryDefaults(directory, defaults)
+	if err != nil {
+		fmt.Fprintf(output, "%s: %s\n", path.Join(directory, godoit.DefaultsFilename), err)
+		problems++
+	}
+	job := godoit.ParseJobFile(directory, filename, defaults)
+	if job == nil {
+		fmt.Fprintf(output, "%s: not a %s job file\n", path.Join(directory, filename), godoit.GodoitFileSuffix)
+		return 0, problems + 1
+	}
+	return 1, problems + reportJob(*job, output)
+}
+
+// reportJob writes the errors in the job, with their line numbers where known
+func reportJob(job godoit.Job, output io.Writer) int {
+	for i, jobError := range job.Errors {
+		if job.ErrorLines[i] > 0 {
+			fmt.Fprintf(output, "%s:%d: %s\n", job.Filepath, job.ErrorLines[i], jobError)
+		} else {
+			fmt.Fprintf(output, "%s: %s\n", job.Filepath, jobError)
+		}
+	}
+	return len(job.Errors)
+}
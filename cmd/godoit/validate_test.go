@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"bytes"
+	"os"
+	"path"
+	"fmt"
+	"io/ioutil"
+)
+
+func TestValidateValidJobs(t *testing.T) {
+	withDir(func(dir string) {
+		createTestJob(dir, "0 30 * * * * job1.godoit")
+		createTestJob(dir, "job2.godoit", "#:godoit cronspec 0 0 * * * *", "#:godoit timezone Europe/London")
+		createTestJob(dir, "readme.txt")
+
+		output := new(bytes.Buffer)
+		assert.Equal(t, 0, Validate([]string{dir}, output))
+		assert.Equal(t, "Checked 2 jobs, found 0 problems\n", output.String())
+	})
+}
+
+func TestValidateReportsErrorsWithLines(t *testing.T) {
+	withDir(func(dir string) {
+		createTestJob(dir, "0 30 * * * * job1.godoit")
+		createTestJob(
+			dir,
+			"job2.godoit",
+			"#!/bin/bash",
+			"#:godoit cronspec 0 0 * *",
+			"#:godoit timezone Europe/Londres")
+
+		output := new(bytes.Buffer)
+		assert.Equal(t, 1, Validate([]string{dir, path.Join(dir, "0 30 * * * * job1.godoit")}, output))
+		assert.Equal(
+			t,
+			fmt.Sprintf(
+				"%s/job2.godoit:2: Invalid cronspec: '0 0 * *'\n" +
+				"%s/job2.godoit:3: Invalid timezone: 'Europe/Londres'\n" +
+				"%s/job2.godoit: Missing cronspec\n" +
+				"Checked 3 jobs, found 3 problems\n",
+				dir, dir, dir),
+			output.String())
+	})
+}
+
+func TestValidateConfig(t *testing.T) {
+	withDir(func(dir string) {
+		createTestJob(dir, "0 30 * * * * job1.godoit")
+		cfgFile := path.Join(dir, "godoit.conf")
+		writeFile(
+			cfgFile,
+			"include = [ '" + dir + "' ]",
+			"jobExecutorScript = '../../test_wrapper.sh'",
+			"statusScript = './missing.sh'",
+			"jobUser = 'nosuchuser'")
+
+		output := new(bytes.Buffer)
+		assert.Equal(t, 1, Validate([]string{"-config", cfgFile}, output))
+		assert.Equal(
+			t,
+			cfgFile + ":3: statusScript './missing.sh' is not an executable file\n" +
+			cfgFile + ":4: Invalid user: 'nosuchuser'\n" +
+			dir + "/0 30 * * * * job1.godoit: Invalid user: 'nosuchuser'\n" +
+			"Checked 1 jobs, found 3 problems\n",
+			output.String())
+	})
+}
+
+func writeFile(file string, lines ...string) {
+	f, _ := os.Create(file)
+	for _, line := range lines {
+		f.WriteString(line)
+		f.WriteString("\n")
+	}
+	f.Close()
+}
+
+func withDir(aFunc func(dir string)) {
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	aFunc(dir)
+}
+
+func createTestJob(dir string, file string, lines ...string) {
+	writeFile(path.Join(dir, file), lines...)
+}
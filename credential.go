@@ -0,0 +1,116 @@
+package godoit
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// resolveCredential looks up the user and group the job should run as. Jobs
+// without a user or group run with the credentials of godoit itself.
+func resolveCredential(job *Job) {
+	if job.User == "" && job.Group == "" {
+		return
+	}
+
+	credential := &syscall.Credential{
+		Uid: uint32(os.Getuid()),
+		Gid: uint32(os.Getgid()),
+		NoSetGroups: os.Getuid() != 0}
+
+	if job.User != "" {
+		u, err := user.Lookup(job.User)
+		if err != nil {
+			job.Errors = append(job.Errors, fmt.Sprintf("Invalid user: '%s'", job.User))
+			return
+		}
+		credential.Uid = parseId(u.Uid)
+		credential.Gid = parseId(u.Gid)
+		if groupIds, err := u.GroupIds(); err == nil {
+			for _, groupId := range groupIds {
+				credential.Groups = append(credential.Groups, parseId(groupId))
+			}
+		}
+	}
+
+	if job.Group != "" {
+		g, err := user.LookupGroup(job.Group)
+		if err != nil {
+			job.Errors = append(job.Errors, fmt.Sprintf("Invalid group: '%s'", job.Group))
+			return
+		}
+		credential.Gid = parseId(g.Gid)
+	}
+	job.Credential = credential
+}
+
+// checkOwnership ensures a job file owned by a user other than root only runs
+// as that user and with one of that user's groups, so users cannot deploy jobs
+// which run with more privileges.
+func checkOwnership(job *Job) {
+	info, err := os.Stat(job.Filepath)
+	if err != nil {
+		return
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Uid == 0 {
+		return
+	}
+
+	runAs := uint32(os.Getuid())
+	if job.Credential != nil {
+		runAs = job.Credential.Uid
+	}
+	runAsGroup := uint32(os.Getgid())
+	if job.Credential != nil {
+		runAs, runAsGroup = job.Credential.Uid, job.Credential.Gid
+	}
+	if runAs != stat.Uid {
+		job.Errors = append(
+			job.Errors,
+			fmt.Sprintf("Job owned by '%s' cannot run as '%s'", userName(stat.Uid), userName(runAs)))
+	} else if !memberOf(stat.Uid, runAsGroup) {
+		job.Errors = append(
+			job.Errors,
+			fmt.Sprintf("Job owned by '%s' cannot run as group '%s'", userName(stat.Uid), groupName(runAsGroup)))
+	}
+}
+
+// memberOf returns whether the group is the primary or a supplementary group
+// of the user
+func memberOf(uid, gid uint32) bool {
+	u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10))
+	if err != nil {
+		return false
+	}
+	groupIds, _ := u.GroupIds()
+	for _, groupId := range append(groupIds, u.Gid) {
+		if parseId(groupId) == gid {
+			return true
+		}
+	}
+	return false
+}
+
+func groupName(gid uint32) string {
+	id := strconv.FormatUint(uint64(gid), 10)
+	if g, err := user.LookupGroupId(id); err == nil {
+		return g.Name
+	}
+	return id
+}
+
+func userName(uid uint32) string {
+	id := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(id); err == nil {
+		return u.Username
+	}
+	return id
+}
+
+func parseId(id string) uint32 {
+	value, _ := strconv.ParseUint(id, 10, 32)
+	return uint32(value)
+}
@@ -0,0 +1,75 @@
+package godoit
+
+import (
+	"fmt"
+	"time"
+	"github.com/robfig/cron"
+)
+
+// dateParameterFormats are the formats of the from and until parameters, which
+// are in the timezone of the job
+var dateParameterFormats = []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02 15:04:05"}
+
+// applyDateParameter parses the from or until parameter. The times are wall
+// clock times until the job's timezone is known, an until date without a time
+// includes the whole day.
+func applyDateParameter(job *Job, param, value string) {
+	for i, format := range dateParameterFormats {
+		if t, err := time.ParseInLocation(format, value, time.UTC); err == nil {
+			if param == "from" {
+				job.From = t
+			} else if i == 0 {
+				job.Until = t.Add(24 * time.Hour - time.Second)
+			} else {
+				job.Until = t
+			}
+			return
+		}
+	}
+	job.Errors = append(job.Errors, fmt.Sprintf("Invalid %s: '%s'", param, value))
+}
+
+// inTimezone returns the time a wall clock time read in UTC is in a location
+func inTimezone(t time.Time, location *time.Location) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, location)
+}
+
+// dateRangeSchedule only runs a schedule from a time until a time, either may
+// be zero for no limit
+type dateRangeSchedule struct {
+	schedule cron.Schedule
+	from time.Time
+	until time.Time
+}
+
+func (schedule dateRangeSchedule) Next(t time.Time) time.Time {
+	next, _ := schedule.next(t)
+	return next
+}
+
+func (schedule dateRangeSchedule) next(t time.Time) (time.Time, string) {
+	if t.Before(schedule.from) {
+		t = schedule.from.Add(-time.Nanosecond)
+	}
+	next, note := nextWithNote(schedule.schedule, t)
+	if !schedule.until.IsZero() && next.After(schedule.until) {
+		return time.Time{}, ""
+	}
+	return next, note
+}
+
+// inactiveReason returns why an enabled job will not run again, its until time
+// has passed or it has run maxruns times, or an empty string if it will
+func inactiveReason(job Job, runs int, now time.Time) string {
+	if !job.Enabled {
+		return ""
+	} else if job.MaxRuns > 0 && runs >= job.MaxRuns {
+		return fmt.Sprintf("ran maxruns %d times", job.MaxRuns)
+	} else if !job.Until.IsZero() && now.After(job.Until) {
+		return fmt.Sprintf("until %s has passed", job.Until.Format("2006-01-02 15:04:05 MST"))
+	}
+	return ""
+}
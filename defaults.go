@@ -0,0 +1,45 @@
+package godoit
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultsFilename is the file in a directory of jobs which sets parameters for
+// every job in the directory, one `<param> <value>` per line.
+const DefaultsFilename = ".godoit-defaults"
+
+// DirectoryDefaults returns the defaults with the parameters from the defaults
+// file in the directory applied over them. The defaults are returned unchanged
+// if there is no defaults file.
+func DirectoryDefaults(directory string, defaults JobDefaults) (JobDefaults, error) {
+	merged := make(JobDefaults)
+	for param, value := range defaults {
+		merged[param] = value
+	}
+
+	file, err := os.Open(filepath.Join(directory, DefaultsFilename))
+	if os.IsNotExist(err) {
+		return merged, nil
+	} else if err != nil {
+		return merged, fmt.Errorf("Unable to read %s", DefaultsFilename)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			return merged, fmt.Errorf("Invalid parameter '%s' in %s line %d", line, DefaultsFilename, i)
+		}
+		merged[parts[0]] = strings.TrimSpace(parts[1])
+	}
+	return merged, nil
+}
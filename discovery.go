@@ -0,0 +1,233 @@
+package godoit
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Directories returns the directories matching the include patterns, and the
+// directories below them when Recursive is set. Directories starting with `.`
+// are skipped when looking below a directory. Directories below the start of
+// an include pattern which match an exclude pattern or are ignored by the
+// ignore file of the directory above, and the directories below them, are not
+// returned.
+func (options ScannerOptions) Directories() []string {
+	directories, _, _ := options.discover()
+	return directories
+}
+
+// discovery finds the directories to scan, remembering the ignore files read
+// and the directories excluded with the reason each was excluded
+type discovery struct {
+	options ScannerOptions
+	root string
+	ignores map[string]IgnoreRules
+	excluded []string
+	reasons map[string]string
+	checked map[string]bool
+}
+
+// discover returns the directories to scan, the directories excluded and why
+// each was excluded
+func (options ScannerOptions) discover() ([]string, []string, map[string]string) {
+	finder := &discovery{
+		options: options,
+		ignores: make(map[string]IgnoreRules),
+		reasons: make(map[string]string),
+		checked: make(map[string]bool)}
+	found := make(map[string]bool)
+	directories := []string{}
+	for _,element := range options.Include {
+		pattern := path.Clean(os.ExpandEnv(element))
+		finder.root = includeRoot(pattern)
+		log.Printf("  Scanning directories matching %s", pattern)
+		matches, err := finder.globDirectories(pattern)
+		if err != nil {
+			log.Printf("  Failed to scan, %s", pattern)
+			continue
+		}
+		for _,match := range matches {
+			below := []string{match}
+			if options.Recursive {
+				below = finder.subdirectories(match)
+			}
+			for _,directory := range below {
+				if !found[directory] {
+					found[directory] = true
+					directories = append(directories, directory)
+				}
+			}
+		}
+	}
+	return directories, finder.excluded, finder.reasons
+}
+
+// includeRoot returns the directory above the first part of the pattern with a
+// wildcard, or above the directory the pattern names if it has none. Excludes
+// and ignore files only apply below it, so an exclude such as `tmp` does not
+// exclude every directory included from `/tmp`.
+func includeRoot(pattern string) string {
+	parts := strings.Split(pattern, "/")
+	for i, part := range parts {
+		if strings.ContainsAny(part, `*?[\`) {
+			if i == 0 {
+				return "."
+			} else if i == 1 && parts[0] == "" {
+				return "/"
+			}
+			return strings.Join(parts[:i], "/")
+		}
+	}
+	return filepath.Dir(pattern)
+}
+
+// isBelow returns whether the path is in a directory below the root
+func isBelow(path, root string) bool {
+	relative, err := filepath.Rel(root, path)
+	return err == nil && relative != "." && relative != ".." && !strings.HasPrefix(relative, "../")
+}
+
+// logExcluded logs the paths which are excluded and were not excluded before,
+// so each is logged when it is first excluded rather than on every scan
+func logExcluded(kind string, previous, excluded []string, reasons map[string]string) {
+	known := make(map[string]bool)
+	for _, path := range previous {
+		known[path] = true
+	}
+	for _, path := range excluded {
+		if !known[path] {
+			log.Printf("  Excluding %s %s, %s", kind, path, reasons[path])
+		}
+	}
+}
+
+// globDirectories returns the directories matching the pattern, where a `**`
+// part matches any number of directories
+func (finder *discovery) globDirectories(pattern string) ([]string, error) {
+	parts := strings.Split(pattern, "/")
+	wildcard := -1
+	for i, part := range parts {
+		if part == "**" {
+			wildcard = i
+			break
+		}
+	}
+
+	if wildcard < 0 {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		directories := []string{}
+		for _,match := range matches {
+			if info, err := os.Stat(match); err == nil && info.IsDir() && !finder.excludedPath(match) {
+				directories = append(directories, match)
+			}
+		}
+		return directories, nil
+	}
+
+	base := strings.Join(parts[:wildcard], "/")
+	if base == "" && strings.HasPrefix(pattern, "/") {
+		base = "/"
+	} else if base == "" {
+		base = "."
+	}
+	rest := strings.Join(parts[wildcard+1:], "/")
+	bases, err := finder.globDirectories(base)
+	if err != nil {
+		return nil, err
+	}
+	directories := []string{}
+	for _,baseDirectory := range bases {
+		for _,directory := range finder.subdirectories(baseDirectory) {
+			if rest == "" {
+				directories = append(directories, directory)
+				continue
+			}
+			matches, err := finder.globDirectories(filepath.Join(directory, rest))
+			if err != nil {
+				return nil, err
+			}
+			directories = append(directories, matches...)
+		}
+	}
+	return directories, nil
+}
+
+// subdirectories returns the directory and the directories below it, up to
+// MaxDepth levels below or all levels if MaxDepth is 0. Symbolic links below
+// the directory are not followed.
+func (finder *discovery) subdirectories(root string) []string {
+	directories := []string{root}
+	finder.addSubdirectories(root, 1, &directories)
+	return directories
+}
+
+func (finder *discovery) addSubdirectories(directory string, depth int, directories *[]string) {
+	if finder.options.MaxDepth > 0 && depth > finder.options.MaxDepth {
+		return
+	}
+	files, _ := ioutil.ReadDir(directory)
+	for _,file := range files {
+		if file.IsDir() && !strings.HasPrefix(file.Name(), ".") {
+			subdirectory := filepath.Join(directory, file.Name())
+			if finder.excludes(subdirectory) {
+				continue
+			}
+			*directories = append(*directories, subdirectory)
+			finder.addSubdirectories(subdirectory, depth + 1, directories)
+		}
+	}
+}
+
+// excludedPath returns whether the directory or a directory above it, below
+// the root of the include pattern, is excluded
+func (finder *discovery) excludedPath(directory string) bool {
+	for current := directory; isBelow(current, finder.root); current = filepath.Dir(current) {
+		if finder.excludes(current) {
+			return true
+		}
+	}
+	return false
+}
+
+// excludes returns whether the directory matches an exclude pattern or is
+// ignored by the ignore file in the directory above, recording why
+func (finder *discovery) excludes(directory string) bool {
+	excluded, ok := finder.checked[directory]
+	if ok {
+		return excluded
+	}
+
+	parent := filepath.Dir(directory)
+	if pattern, matched := excludedBy(finder.options.Exclude, directory); matched {
+		finder.reasons[directory] = "matches " + pattern
+		excluded = true
+	} else if parent != directory && finder.ignoreRules(parent).Ignored(filepath.Base(directory), true) {
+		finder.reasons[directory] = "ignored by " + filepath.Join(parent, IgnoreFilename)
+		excluded = true
+	}
+	finder.checked[directory] = excluded
+	if excluded {
+		finder.excluded = append(finder.excluded, directory)
+	}
+	return excluded
+}
+
+func (finder *discovery) ignoreRules(directory string) IgnoreRules {
+	rules, ok := finder.ignores[directory]
+	if !ok {
+		var err error
+		rules, err = ReadIgnoreFile(directory)
+		if err != nil {
+			log.Printf("Errors parsing %s in %s: %s", IgnoreFilename, directory, err)
+		}
+		finder.ignores[directory] = rules
+	}
+	return rules
+}
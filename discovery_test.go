@@ -0,0 +1,105 @@
+package godoit
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+)
+
+func withDirectoryTree(aFunc withDirFunc) {
+	withDir(func(dir string) {
+		for _, directory := range []string{"app1/jobs/daily", "app1/.git/jobs", "app2/jobs", "app3/lib"} {
+			os.MkdirAll(path.Join(dir, directory), 0755)
+		}
+		aFunc(dir)
+	})
+}
+
+func TestDirectoriesMatchingDoubleStar(t *testing.T) {
+	withDirectoryTree(func(dir string) {
+		options := ScannerOptions{Include: []string{dir + "/**/jobs"}}
+		assert.Equal(t, []string{dir + "/app1/jobs", dir + "/app2/jobs"}, options.Directories())
+
+		options = ScannerOptions{Include: []string{dir + "/app1/**"}}
+		assert.Equal(t, []string{dir + "/app1", dir + "/app1/jobs", dir + "/app1/jobs/daily"}, options.Directories())
+
+		options = ScannerOptions{Include: []string{dir + "/**/daily"}, MaxDepth: 1}
+		assert.Equal(t, []string{}, options.Directories())
+
+		options = ScannerOptions{Include: []string{dir + "/**/daily"}, MaxDepth: 2}
+		assert.Equal(t, []string{dir + "/app1/jobs/daily"}, options.Directories())
+	})
+}
+
+func TestRecursiveDirectories(t *testing.T) {
+	withDirectoryTree(func(dir string) {
+		options := ScannerOptions{Include: []string{dir + "/app*"}, Recursive: true}
+		assert.Equal(
+			t,
+			[]string{
+				dir + "/app1",
+				dir + "/app1/jobs",
+				dir + "/app1/jobs/daily",
+				dir + "/app2",
+				dir + "/app2/jobs",
+				dir + "/app3",
+				dir + "/app3/lib"},
+			options.Directories())
+
+		options = ScannerOptions{Include: []string{dir + "/app1", dir + "/app2"}, Recursive: true, MaxDepth: 1}
+		assert.Equal(t, []string{dir + "/app1", dir + "/app1/jobs", dir + "/app2", dir + "/app2/jobs"}, options.Directories())
+	})
+}
+
+func TestExcludedDirectories(t *testing.T) {
+	withDirectoryTree(func(dir string) {
+		options := ScannerOptions{Include: []string{dir + "/app*"}, Exclude: []string{"app2"}, Recursive: true}
+		directories, excluded, reasons := options.discover()
+		assert.Equal(t, []string{dir + "/app1", dir + "/app1/jobs", dir + "/app1/jobs/daily", dir + "/app3", dir + "/app3/lib"}, directories)
+		assert.Equal(t, []string{dir + "/app2"}, excluded)
+		assert.Equal(t, map[string]string{dir + "/app2": "matches app2"}, reasons)
+
+		// Directories above the start of the include pattern are not excluded
+		options = ScannerOptions{Include: []string{dir + "/app*"}, Exclude: []string{path.Base(dir)}}
+		assert.Equal(t, []string{dir + "/app1", dir + "/app2", dir + "/app3"}, options.Directories())
+		options = ScannerOptions{Include: []string{dir + "/app1/jobs"}, Exclude: []string{"app1"}}
+		assert.Equal(t, []string{dir + "/app1/jobs"}, options.Directories())
+		options = ScannerOptions{Include: []string{dir + "/app1/jobs"}, Exclude: []string{"jobs"}}
+		assert.Equal(t, []string{}, options.Directories())
+
+		options = ScannerOptions{Include: []string{dir + "/**/jobs"}, Exclude: []string{dir + "/**/jobs/daily"}, Recursive: true}
+		assert.Equal(t, []string{dir + "/app1/jobs", dir + "/app2/jobs"}, options.Directories())
+
+		createTestJob(dir, IgnoreFilename, "app*/", "!app3/")
+		createTestJob(dir + "/app3", IgnoreFilename, "lib")
+		options = ScannerOptions{Include: []string{dir + "/*"}, Recursive: true}
+		assert.Equal(t, []string{dir + "/app3"}, options.Directories())
+	})
+}
+
+func TestIgnoreRules(t *testing.T) {
+	withDir(func(dir string) {
+		rules, err := ReadIgnoreFile(dir)
+		assert.Nil(t, err)
+		assert.False(t, rules.Ignored("job.godoit", false))
+
+		createTestJob(dir, IgnoreFilename, "# Comment", "", "*.godoit", "!keep.godoit", "archive/", "/staging")
+		rules, err = ReadIgnoreFile(dir)
+		assert.Nil(t, err)
+		assert.True(t, rules.Ignored("job.godoit", false))
+		assert.False(t, rules.Ignored("keep.godoit", false))
+		assert.True(t, rules.Ignored("archive", true))
+		assert.False(t, rules.Ignored("archive", false))
+		assert.True(t, rules.Ignored("staging", true))
+
+		createTestJob(dir, IgnoreFilename, "staging", "[a-")
+		_, err = ReadIgnoreFile(dir)
+		assert.Equal(t, "Invalid pattern '[a-' in .godoitignore line 2", err.Error())
+	})
+}
+
+func TestScannerMustHaveExecutor(t *testing.T) {
+	_, err := NewScanner(ScannerOptions{Include: []string{"/tmp/*"}})
+	assert.Equal(t, "Scanner has no executor", err.Error())
+}
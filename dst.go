@@ -0,0 +1,133 @@
+package godoit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"github.com/robfig/cron"
+)
+
+// Policies for the times of a job which do not exist, or which occur twice,
+// when the clocks change for daylight saving
+const (
+	// DSTSkip does not run the job at these times
+	DSTSkip = "skip"
+	// DSTOnce runs the job once, at the first of two times or when the clocks
+	// have gone forward past a time which does not exist
+	DSTOnce = "once"
+	// DSTBoth runs the job at both of two times, and as DSTOnce for a time
+	// which does not exist
+	DSTBoth = "both"
+)
+
+func isDSTPolicy(policy string) bool {
+	return policy == DSTSkip || policy == DSTOnce || policy == DSTBoth
+}
+
+// dstSchedule is a cron schedule of the wall clock times in a location which
+// applies a policy to the times which do not exist or occur twice
+type dstSchedule struct {
+	schedule cron.Schedule
+	location *time.Location
+	policy string
+}
+
+func (schedule dstSchedule) Next(t time.Time) time.Time {
+	next, _ := schedule.next(t)
+	return next
+}
+
+// next returns the first time after t the schedule runs and a note if the
+// daylight saving policy affected it
+func (schedule dstSchedule) next(t time.Time) (time.Time, string) {
+	t = t.In(schedule.location)
+	start := wallClock(t)
+	if instants := localInstants(start, schedule.location); len(instants) > 1 && t.Before(instants[len(instants)-1]) {
+		// The times after t which occur twice come round again once the
+		// clocks go back
+		start = start.Add(-instants[len(instants)-1].Sub(instants[0]))
+	}
+
+	skipped := ""
+	for wall := schedule.schedule.Next(start); !wall.IsZero(); wall = schedule.schedule.Next(wall) {
+		instants := localInstants(wall, schedule.location)
+		missing := len(instants) == 0
+		note := ""
+		if missing {
+			instants = []time.Time{gapInstant(wall, schedule.location)}
+			note = fmt.Sprintf("%s does not exist in %s", wall.Format("2006-01-02 15:04:05"), schedule.location)
+		} else if len(instants) > 1 {
+			note = fmt.Sprintf("%s occurs twice in %s", wall.Format("2006-01-02 15:04:05"), schedule.location)
+		}
+		if !instants[len(instants)-1].After(t) {
+			continue
+		}
+
+		if note != "" && schedule.policy == DSTSkip {
+			if skipped == "" {
+				skipped = "skipped " + note
+			}
+			continue
+		} else if missing {
+			note += ", run at " + instants[0].Format("15:04:05 MST")
+		} else if len(instants) > 1 && schedule.policy == DSTOnce {
+			instants = instants[:1]
+			note += ", run once"
+		} else if len(instants) > 1 {
+			note += ", run at both"
+		}
+		for _, instant := range instants {
+			if instant.After(t) {
+				return instant, joinNotes(skipped, note)
+			}
+		}
+	}
+	return time.Time{}, ""
+}
+
+func joinNotes(notes ...string) string {
+	nonEmpty := []string{}
+	for _, note := range notes {
+		if note != "" {
+			nonEmpty = append(nonEmpty, note)
+		}
+	}
+	return strings.Join(nonEmpty, "; ")
+}
+
+// wallClock returns the time shown on a clock in the time's location as a UTC
+// time, which has no daylight saving
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// localInstants returns the times in the location at which a clock shows the
+// wall clock time, none if the clocks go forward past it and two if the clocks
+// go back over it
+func localInstants(wall time.Time, location *time.Location) []time.Time {
+	instants := []time.Time{}
+	offsets := make(map[int]bool)
+	for _, probe := range []time.Time{wall.Add(-24 * time.Hour), wall, wall.Add(24 * time.Hour)} {
+		_, offset := probe.In(location).Zone()
+		if offsets[offset] {
+			continue
+		}
+		offsets[offset] = true
+		instant := wall.Add(-time.Duration(offset) * time.Second).In(location)
+		if wallClock(instant).Equal(wall) {
+			instants = append(instants, instant)
+		}
+	}
+	sort.Slice(instants, func(i, j int) bool {
+		return instants[i].Before(instants[j])
+	})
+	return instants
+}
+
+// gapInstant returns the time a wall clock time which does not exist would
+// have been if the clocks had not gone forward
+func gapInstant(wall time.Time, location *time.Location) time.Time {
+	_, offset := wall.Add(-24 * time.Hour).In(location).Zone()
+	return wall.Add(-time.Duration(offset) * time.Second).In(location)
+}
@@ -0,0 +1,58 @@
+package godoit_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"time"
+	"github.com/timjwright/godoit"
+)
+
+// An agent can schedule the jobs in its own directories and run them itself
+// rather than with a wrapper script.
+func ExampleScanner() {
+	executor := godoit.ExecutorFunc(func(job godoit.Job) godoit.RunResult {
+		result := godoit.RunResult{Start: time.Now()}
+		log.Printf("Running %s from %s", job.Name, job.Filepath)
+		result.Duration = time.Since(result.Start)
+		return result
+	})
+
+	scanner, err := godoit.NewScanner(godoit.ScannerOptions{
+		Include: []string{"/opt/agent/jobs/*"},
+		Defaults: godoit.JobDefaults{"timeout": "10m"},
+		Executor: executor,
+		MaxConcurrentJobs: 4})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer scanner.Stop()
+
+	// Pick up new, changed and removed jobs every minute
+	reporter := godoit.StatusReporterFunc(func(info godoit.GodoitInfo) {
+		for _, collection := range info.JobInfo {
+			log.Printf("%s has %d jobs", collection.Path, len(collection.Jobs))
+		}
+	})
+	for range time.Tick(time.Minute) {
+		scanner.Run()
+		reporter.Report(scanner.Status([]string{}))
+	}
+}
+
+func ExampleParseJobFile() {
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(path.Join(dir, "0 30 2 * * * backup.godoit"), []byte("#:godoit timezone Europe/London\n"), 0644)
+
+	job := godoit.ParseJobFile(dir, "0 30 2 * * * backup.godoit", godoit.JobDefaults{})
+	fmt.Println(job.Name, job.Spec, job.Timezone, job.Enabled)
+
+	next := job.Next(time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC))
+	fmt.Println(next.UTC())
+	// Output:
+	// backup 0 30 2 * * * Europe/London true
+	// 2017-07-02 01:30:00 +0000 UTC
+}
@@ -1,4 +1,4 @@
-package main
+package godoit
 
 import (
 	"os"
@@ -9,43 +9,194 @@ import (
 	"syscall"
 )
 
-type JobExecutor func(jobName, jobPath string, timeout time.Duration)
+// Executor runs a job and reports how the run went
+type Executor interface {
+	Execute(job Job) RunResult
+}
 
-func JobExecutorFromScript(jobExecutorScript string, output io.Writer) JobExecutor {
-	if len(jobExecutorScript) == 0 {
-		log.Fatal("Job executor is not defined")
-	}
-	jobExecutorScript = os.ExpandEnv(jobExecutorScript)
-	return func(jobName, jobPath string, timeout time.Duration) {
-		cmd := exec.Command(jobExecutorScript, jobName, jobPath)
-		log.Printf("Running comand line: %s '%s' '%s' Timeout: %s", jobExecutorScript, jobName, jobPath, timeout)
-		cmd.Stdout = output
-		cmd.Stderr = output
-		err := runWithTimout(cmd, timeout)
+// ExecutorFunc allows a function to be used as an Executor
+type ExecutorFunc func(job Job) RunResult
+
+func (f ExecutorFunc) Execute(job Job) RunResult {
+	return f(job)
+}
+
+// RunResult describes a single run of a job. The peak memory and CPU time are
+// only known when the job runs in a cgroup, the exit code is -1 when the job is
+// not a process which exited and the HTTP status is only set by the http
+// executor.
+type RunResult struct {
+	Executor string
+	Jitter time.Duration
+	QueueWait time.Duration
+	Start time.Time
+	Duration time.Duration
+	Error error
+	TimedOut bool
+	ExitCode int
+	HTTPStatus int
+	PeakMemory uint64
+	CPUTime time.Duration
+}
+
+type terminator func(cmd *exec.Cmd) error
+
+// terminateGracePeriod is how long a job which timed out has to exit after
+// SIGTERM before it is killed
+var terminateGracePeriod = 10 * time.Second
+
+// JobExecutorFromScript returns an Executor which runs the script with the name
+// and path of each job, or an error if the script is not defined
+func JobExecutorFromScript(jobExecutorScript, cgroupParent string, output io.Writer, clock Clock) (Executor, error) {
+	return newScriptExecutor(ExecutorOptions{Script: jobExecutorScript, CgroupParent: cgroupParent, Output: output, Clock: clock})
+}
+
+// commandExecutor runs the command line for each job as the job's user with its
+// resource limits, in a cgroup when a cgroup parent is configured. Each job
+// runs in its own process group, so a timeout stops the processes it started.
+func commandExecutor(commandLine func(job Job) []string, cgroupParent string, output io.Writer, clock Clock) Executor {
+	cgroupParent = os.ExpandEnv(cgroupParent)
+	return ExecutorFunc(func(job Job) RunResult {
+		result := RunResult{Start: clock.Now(), ExitCode: -1}
+		command := commandLine(job)
+		name, args := limitCommand(job.Limits, command[0], command[1:]...)
+		log.Printf("Running comand line: %s Timeout: %s", quoteCommand(append([]string{name}, args...)), job.Timeout)
+		newCommand := func() *exec.Cmd {
+			cmd := exec.Command(name, args...)
+			cmd.Stdout = output
+			cmd.Stderr = output
+			// Processes the job leaves running may keep the output open
+			cmd.WaitDelay = terminateGracePeriod
+			if job.TriggerFile != "" {
+				cmd.Env = append(os.Environ(), TriggerFileEnv + "=" + job.TriggerFile)
+			}
+			cmd.SysProcAttr = &syscall.SysProcAttr{Credential: job.Credential, Setpgid: true}
+			return cmd
+		}
+		if job.Credential != nil {
+			log.Printf("  Running as uid %d gid %d", job.Credential.Uid, job.Credential.Gid)
+		}
+
+		cmd := newCommand()
+		terminate, kill := terminateProcess, killProcess
+		cgroup := jobCgroupFor(cgroupParent, job)
+		if cgroup != nil {
+			defer cgroup.remove()
+			cmd.SysProcAttr = cgroup.procAttr(cmd.SysProcAttr)
+			terminate = func(cmd *exec.Cmd) error {
+				return cgroup.signal(syscall.SIGTERM)
+			}
+			kill = func(cmd *exec.Cmd) error {
+				return cgroup.kill()
+			}
+		}
+
+		err := cmd.Start()
+		if err != nil && cgroup != nil {
+			// Older kernels cannot start a process in a cgroup
+			log.Printf("WARNING: Running %s without a cgroup, unable to start it in %s: %s", job.Name, cgroup.path, err)
+			cgroup = nil
+			cmd = newCommand()
+			terminate, kill = terminateProcess, killProcess
+			err = cmd.Start()
+		}
 		if err != nil {
-			log.Printf("ERROR: Failed to execute executor script %s %s %s", jobExecutorScript, jobName, jobPath)
+			result.Error = err
+		} else {
+			result.TimedOut, result.Error = runWithTimout(clock, cmd, job.Timeout, terminate, kill)
 		}
+		result.Duration = clock.Now().Sub(result.Start)
+		if cmd.ProcessState != nil {
+			result.ExitCode = cmd.ProcessState.ExitCode()
+		}
+		if cgroup != nil {
+			cgroup.stats(&result)
+		}
+		if result.Error != nil {
+			log.Printf("ERROR: Failed to execute %s: %s", quoteCommand(command), result.Error)
+		}
+		return result
+	})
+}
+
+// quoteCommand returns the command line for logging with the arguments quoted
+func quoteCommand(command []string) string {
+	line := command[0]
+	for _, arg := range command[1:] {
+		line += " '" + arg + "'"
+	}
+	return line
+}
+
+// jobCgroupFor creates a cgroup for the run when a cgroup parent is configured,
+// jobs run without a cgroup if one cannot be created.
+func jobCgroupFor(cgroupParent string, job Job) *jobCgroup {
+	if cgroupParent == "" {
+		if job.Limits.Memory > 0 || job.Limits.CPU > 0 {
+			log.Printf("WARNING: No cgroup parent configured, memory and cpu limits for %s are ignored", job.Name)
+		}
+		return nil
+	}
+	cgroup, err := newJobCgroup(cgroupParent, job)
+	if err != nil {
+		log.Printf("WARNING: Running %s without a cgroup, cgroups are unavailable: %s", job.Name, err)
+		return nil
 	}
+	return cgroup
 }
 
-func runWithTimout(cmd *exec.Cmd, timeout time.Duration) error {
+func terminateProcess(cmd *exec.Cmd) error {
+	return signalProcess(cmd, syscall.SIGTERM)
+}
+
+func killProcess(cmd *exec.Cmd) error {
+	return signalProcess(cmd, syscall.SIGKILL)
+}
+
+// signalProcess sends the signal to the process group of the command when it
+// has its own, so the processes it started are also signalled
+func signalProcess(cmd *exec.Cmd, signal syscall.Signal) error {
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.Setpgid {
+		return syscall.Kill(-cmd.Process.Pid, signal)
+	}
+	return cmd.Process.Signal(signal)
+}
+
+// runWithTimout runs the command, starting it if it has not been started,
+// terminating it if it runs for longer than the timeout and killing it if it
+// is still running after the grace period. Returns whether the command timed
+// out once the command has exited.
+func runWithTimout(clock Clock, cmd *exec.Cmd, timeout time.Duration, terminate, kill terminator) (bool, error) {
+	if cmd.Process == nil {
+		if err := cmd.Start(); err != nil {
+			return false, err
+		}
+	}
 	if timeout.Seconds() <= 0 {
-		return cmd.Run()
+		return false, cmd.Wait()
 	} else {
 		done := make(chan error, 1)
 		go func() {
-			cmd.Start()
 			done <- cmd.Wait()
 		}()
 		select {
-		case <-time.After(timeout):
-			if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		case <-clock.After(timeout):
+			if err := terminate(cmd); err != nil {
 				log.Printf("ERROR: Failed to terminate job %s error: %s", cmd.Path, err)
 			}
 			log.Printf("Job %s timed out", cmd.Path)
-			return nil
+			select {
+			case <-clock.After(terminateGracePeriod):
+				log.Printf("Job %s did not exit within %s, killing it", cmd.Path, terminateGracePeriod)
+				if err := kill(cmd); err != nil {
+					log.Printf("ERROR: Failed to kill job %s error: %s", cmd.Path, err)
+				}
+				<-done
+			case <-done:
+			}
+			return true, nil
 		case err := <-done:
-			return err
+			return false, err
 		}
 	}
 }
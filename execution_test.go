@@ -1,23 +1,170 @@
-package main
+package godoit
 
 import (
 	"testing"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"time"
+	"bytes"
+	"fmt"
+	"syscall"
+	"path"
+	"path/filepath"
+	"os/exec"
 )
 
 func TestExecutor(t *testing.T) {
 	// TODO...
-	jobExec := JobExecutorFromScript("./test_wrapper.sh", os.Stdout)
-	jobExec("my job","/path/to/@ 1 @ @ @ @ my job.godoit", noTimeout)
+	jobExec, _ := JobExecutorFromScript("./test_wrapper.sh", "", os.Stdout, RealClock)
+	jobExec.Execute(Job{Name: "my job", Filepath: "/path/to/@ 1 @ @ @ @ my job.godoit", Timeout: noTimeout})
 	assert.True(t, true, "Failed to parse job")
 }
 
+func TestExecutorScriptMustBeDefined(t *testing.T) {
+	_, err := JobExecutorFromScript("", "", os.Stdout, RealClock)
+	assert.Equal(t, "Job executor script is not defined", err.Error())
+}
+
 func TestExecutorWithTimeout(t *testing.T) {
-	jobExec := JobExecutorFromScript("./test_wrapper_sleep.sh", os.Stdout)
+	jobExec, _ := JobExecutorFromScript("./test_wrapper_sleep.sh", "", os.Stdout, RealClock)
 	start := time.Now()
-	jobExec("my job","/path/to/@ 1 @ @ @ @ my job.godoit", time.Second * 3)
+	result := jobExec.Execute(Job{Name: "my job", Filepath: "/path/to/@ 1 @ @ @ @ my job.godoit", Timeout: time.Second * 3})
 	duration := time.Since(start)
 	assert.True(t, duration.Seconds() < 4.0, "Job took to long")
+	assert.True(t, result.TimedOut, "Job should time out")
+	// The exit code is read once the process has exited, it was terminated
+	assert.Equal(t, -1, result.ExitCode)
+}
+
+func TestExecutorStopsProcessesTheJobStarted(t *testing.T) {
+	// The background sleep keeps the output open after the shell exits
+	output := new(bytes.Buffer)
+	jobExec := commandExecutor(func(job Job) []string {
+		return []string{"sh", "-c", "sleep 100 & wait"}
+	}, "", output, RealClock)
+	start := time.Now()
+	result := jobExec.Execute(Job{Name: "my job", Timeout: time.Second})
+	assert.True(t, time.Since(start).Seconds() < 3.0, "Job took to long")
+	assert.True(t, result.TimedOut, "Job should time out")
+
+	defer func(delay time.Duration) { terminateGracePeriod = delay }(terminateGracePeriod)
+	terminateGracePeriod = 100 * time.Millisecond
+	jobExec = commandExecutor(func(job Job) []string {
+		return []string{"sh", "-c", "sleep 3 &"}
+	}, "", output, RealClock)
+	start = time.Now()
+	result = jobExec.Execute(Job{Name: "my job"})
+	assert.True(t, time.Since(start).Seconds() < 2.0, "Job should not wait for its output to close")
+	assert.Equal(t, exec.ErrWaitDelay, result.Error)
+}
+
+func TestRunWithTimeoutUsesClock(t *testing.T) {
+	clock := NewFakeClock(testStartTime)
+	cmd := exec.Command("sleep", "100")
+	done := make(chan bool)
+	go func() {
+		timedOut, _ := runWithTimout(clock, cmd, time.Minute, terminateProcess, killProcess)
+		done <- timedOut
+	}()
+	waitFor(t, func() bool { return clock.Timers() == 1 })
+	clock.Advance(time.Minute)
+	assert.True(t, <-done, "Job should time out")
+}
+
+func TestRunWithTimeoutKillsAfterGracePeriod(t *testing.T) {
+	withDir(func(dir string) {
+		clock := NewFakeClock(testStartTime)
+		started := path.Join(dir, "started")
+		cmd := exec.Command("sh", "-c", "trap '' TERM; touch '" + started + "'; exec sleep 100")
+		done := make(chan bool)
+		go func() {
+			timedOut, _ := runWithTimout(clock, cmd, time.Minute, terminateProcess, killProcess)
+			done <- timedOut
+		}()
+		waitFor(t, func() bool {
+			_, err := os.Stat(started)
+			return err == nil && clock.Timers() == 1
+		})
+		clock.Advance(time.Minute)
+		waitFor(t, func() bool { return clock.Timers() == 1 })
+		select {
+		case <-done:
+			t.Fatal("Job ignoring SIGTERM should run until the grace period ends")
+		default:
+		}
+		clock.Advance(terminateGracePeriod)
+		assert.True(t, <-done, "Job should time out")
+		assert.False(t, cmd.ProcessState.Success())
+	})
+}
+
+func TestExecutorWithCredential(t *testing.T) {
+	output := new(bytes.Buffer)
+	jobExec, _ := JobExecutorFromScript("./test_user.sh", "", output, RealClock)
+	jobExec.Execute(Job{
+		Name: "my job",
+		Filepath: "/path/to/my job.godoit",
+		Credential: &syscall.Credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid()), NoSetGroups: true}})
+	assert.Equal(t, fmt.Sprintf("%d %d\n", os.Getuid(), os.Getgid()), output.String())
+}
+
+func TestExecutorWithLimits(t *testing.T) {
+	output := new(bytes.Buffer)
+	jobExec, _ := JobExecutorFromScript("./test_limits.sh", "", output, RealClock)
+	jobExec.Execute(Job{
+		Name: "my job",
+		Filepath: "/path/to/my job.godoit",
+		Limits: ResourceLimits{Rlimits: map[string]uint64{"nofile": 100, "core": 0}, Nice: 5}})
+	assert.Equal(t, "100 0 5\n", output.String())
+}
+
+func TestLimitCommand(t *testing.T) {
+	name, args := limitCommand(ResourceLimits{}, "script", "job", "path")
+	assert.Equal(t, "script", name)
+	assert.Equal(t, []string{"job", "path"}, args)
+
+	name, args = limitCommand(
+		ResourceLimits{Rlimits: map[string]uint64{"as": 1024, "cpu": 60}, Nice: 10, IOClass: "best-effort", IOLevel: 4},
+		"script", "job", "path")
+	assert.Equal(t, "nice", name)
+	if priority, _ := syscall.Getpriority(syscall.PRIO_PROCESS, 0); priority != 20 {
+		t.Skip("The nice increment is only known when the test runs with nice value 0")
+	}
+	assert.Equal(
+		t,
+		[]string{"-n", "10", "ionice", "-c", "2", "-n", "4", "prlimit", "--as=1024", "--cpu=60", "--", "script", "job", "path"},
+		args)
+}
+
+func TestExecutorWithCgroup(t *testing.T) {
+	parent := "/sys/fs/cgroup/unified/godoit-test"
+	if !isCgroup(path.Dir(parent)) {
+		parent = "/sys/fs/cgroup/godoit-test"
+	}
+	if !isCgroup(path.Dir(parent)) || os.Getuid() != 0 {
+		t.Skip("cgroup v2 is not available")
+	}
+	defer os.Remove(parent)
+
+	jobExec, _ := JobExecutorFromScript("./test_wrapper_sleep.sh", parent, os.Stdout, RealClock)
+	start := time.Now()
+	result := jobExec.Execute(Job{Name: "my job", Filepath: "/path/to/my job.godoit", Timeout: time.Second})
+	assert.True(t, time.Since(start).Seconds() < 3.0, "Job took to long")
+	assert.True(t, result.TimedOut, "Job should time out")
+	assert.Nil(t, result.Error)
+	assert.True(t, result.CPUTime > 0, "CPU time should be read from the cgroup")
+
+	// The whole group is killed so the cgroup is removed
+	groups, _ := filepath.Glob(path.Join(parent, "my_job-*"))
+	assert.Equal(t, 0, len(groups))
+}
+
+func TestExecutorWithoutCgroups(t *testing.T) {
+	withDir(func(dir string) {
+		output := new(bytes.Buffer)
+		jobExec, _ := JobExecutorFromScript("./test_wrapper.sh", dir, output, RealClock)
+		result := jobExec.Execute(Job{Name: "my job", Filepath: "/path/to/my job.godoit", Limits: ResourceLimits{Memory: 1 << 20}})
+		assert.Nil(t, result.Error)
+		assert.Equal(t, "Name my job\nFile /path/to/my job.godoit\n", output.String())
+	})
 }
@@ -0,0 +1,141 @@
+package godoit
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+)
+
+// DefaultExecutor runs jobs which do not name an executor
+const DefaultExecutor = "script"
+
+// ExecutorOptions are the settings executors are created from, each executor
+// uses the settings relevant to it.
+type ExecutorOptions struct {
+	// Script is the wrapper script the script executor runs jobs with
+	Script string
+	// URL is where the http executor posts jobs to
+	URL string
+	// CgroupParent is the cgroup v2 group processes are started under
+	CgroupParent string
+	// Output receives the output of processes
+	Output io.Writer
+	// Clock times the runs, the RealClock if not set
+	Clock Clock
+}
+
+// ExecutorFactory creates an executor from the options, returning an error if
+// the options needed by the executor are missing or invalid.
+type ExecutorFactory func(options ExecutorOptions) (Executor, error)
+
+var executorsLock sync.Mutex
+var executorFactories = map[string]ExecutorFactory{
+	"script": newScriptExecutor,
+	"exec": newExecExecutor,
+	"http": newHTTPExecutor,
+}
+
+// RegisterExecutor makes an executor available to jobs by name, replacing any
+// executor with the same name. Executors must be registered before jobs using
+// them are parsed.
+func RegisterExecutor(name string, factory ExecutorFactory) {
+	executorsLock.Lock()
+	defer executorsLock.Unlock()
+	executorFactories[name] = factory
+}
+
+// UnregisterExecutor removes the executor registered with the name
+func UnregisterExecutor(name string) {
+	executorsLock.Lock()
+	defer executorsLock.Unlock()
+	delete(executorFactories, name)
+}
+
+// IsExecutor returns whether an executor is registered with the name
+func IsExecutor(name string) bool {
+	executorsLock.Lock()
+	defer executorsLock.Unlock()
+	_, ok := executorFactories[name]
+	return ok
+}
+
+// Executors returns the names of the registered executors
+func Executors() []string {
+	executorsLock.Lock()
+	defer executorsLock.Unlock()
+	names := make([]string, 0, len(executorFactories))
+	for name := range executorFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewExecutor creates the executor registered with the name
+func NewExecutor(name string, options ExecutorOptions) (Executor, error) {
+	executorsLock.Lock()
+	factory, ok := executorFactories[name]
+	executorsLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("Invalid executor: '%s'", name)
+	}
+	if options.Clock == nil {
+		options.Clock = RealClock
+	}
+	return factory(options)
+}
+
+// SelectExecutor returns an Executor which runs each job with the executor the
+// job names, or the default executor given. Executors are created when first
+// used, a job whose executor cannot be created fails with the error.
+func SelectExecutor(defaultExecutor string, options ExecutorOptions) Executor {
+	if options.Clock == nil {
+		options.Clock = RealClock
+	}
+	var lock sync.Mutex
+	executors := make(map[string]Executor)
+	return ExecutorFunc(func(job Job) RunResult {
+		name := job.Executor
+		if name == "" {
+			name = defaultExecutor
+		}
+
+		lock.Lock()
+		executor, ok := executors[name]
+		if !ok {
+			var err error
+			if executor, err = NewExecutor(name, options); err != nil {
+				lock.Unlock()
+				log.Printf("ERROR: Unable to run job %s with executor %s: %s", job.Name, name, err)
+				return RunResult{Executor: name, Start: options.Clock.Now(), Error: err, ExitCode: -1}
+			}
+			executors[name] = executor
+		}
+		lock.Unlock()
+
+		result := executor.Execute(job)
+		result.Executor = name
+		return result
+	})
+}
+
+// newScriptExecutor runs the wrapper script with the job name and path
+func newScriptExecutor(options ExecutorOptions) (Executor, error) {
+	if options.Script == "" {
+		return nil, fmt.Errorf("Job executor script is not defined")
+	}
+	script := os.ExpandEnv(options.Script)
+	return commandExecutor(func(job Job) []string {
+		return []string{script, job.Name, job.Filepath}
+	}, options.CgroupParent, options.Output, options.Clock), nil
+}
+
+// newExecExecutor runs the job file itself, which must be executable
+func newExecExecutor(options ExecutorOptions) (Executor, error) {
+	return commandExecutor(func(job Job) []string {
+		return []string{job.Filepath}
+	}, options.CgroupParent, options.Output, options.Clock), nil
+}
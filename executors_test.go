@@ -0,0 +1,134 @@
+package godoit
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"time"
+)
+
+func TestSelectExecutorByName(t *testing.T) {
+	ran := ""
+	RegisterExecutor("test", func(options ExecutorOptions) (Executor, error) {
+		return ExecutorFunc(func(job Job) RunResult {
+			ran = job.Name
+			return RunResult{}
+		}), nil
+	})
+	defer UnregisterExecutor("test")
+	assert.True(t, IsExecutor("test"))
+	assert.Contains(t, Executors(), "exec")
+
+	output := new(bytes.Buffer)
+	executor := SelectExecutor("script", ExecutorOptions{Script: "./test_wrapper.sh", Output: output})
+	result := executor.Execute(Job{Name: "my job", Filepath: "/path/to/my job.godoit", Executor: "test"})
+	assert.Equal(t, "my job", ran)
+	assert.Equal(t, "test", result.Executor)
+
+	result = executor.Execute(Job{Name: "my job", Filepath: "/path/to/my job.godoit"})
+	assert.Equal(t, "script", result.Executor)
+	assert.Equal(t, 0, result.ExitCode)
+	assert.Equal(t, "Name my job\nFile /path/to/my job.godoit\n", output.String())
+
+	// No URL is configured for the http executor
+	result = executor.Execute(Job{Name: "my job", Executor: "http"})
+	assert.EqualError(t, result.Error, "Job executor URL is not defined")
+}
+
+func TestExecExecutor(t *testing.T) {
+	withDir(func(dir string) {
+		output := new(bytes.Buffer)
+		executor, err := NewExecutor("exec", ExecutorOptions{Output: output})
+		assert.Nil(t, err)
+
+		jobPath := path.Join(dir, "job.godoit")
+		ioutil.WriteFile(jobPath, []byte("#!/bin/sh\necho ran\nexit 3\n"), 0755)
+		result := executor.Execute(Job{Name: "job", Filepath: jobPath})
+		assert.Equal(t, "ran\n", output.String())
+		assert.Equal(t, 3, result.ExitCode)
+		assert.NotNil(t, result.Error)
+	})
+}
+
+func TestExecutorPassesTriggerFile(t *testing.T) {
+	withDir(func(dir string) {
+		output := new(bytes.Buffer)
+		executor, _ := NewExecutor("exec", ExecutorOptions{Output: output})
+		jobPath := path.Join(dir, "job.godoit")
+		ioutil.WriteFile(jobPath, []byte("#!/bin/sh\necho \"$GODOIT_TRIGGER_FILE\"\n"), 0755)
+		executor.Execute(Job{Name: "job", Filepath: jobPath, TriggerFile: "/data/in/a.csv"})
+		assert.Equal(t, "/data/in/a.csv\n", output.String())
+	})
+}
+
+func TestHTTPExecutor(t *testing.T) {
+	var posted httpJob
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		json.NewDecoder(r.Body).Decode(&posted)
+		if posted.Name == "failing job" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte("done\n"))
+	}))
+	defer server.Close()
+
+	output := new(bytes.Buffer)
+	executor, err := NewExecutor("http", ExecutorOptions{URL: server.URL, Output: output})
+	assert.Nil(t, err)
+
+	job := Job{Name: "my job", Filepath: "/path/to/my job.godoit", Spec: "0 30 * * * *", Timezone: time.UTC, Timeout: time.Minute, Priority: 2}
+	result := executor.Execute(job)
+	assert.Nil(t, result.Error)
+	assert.Equal(t, 200, result.HTTPStatus)
+	assert.Equal(t, "done\n", output.String())
+	assert.Equal(t, "my job", posted.Name)
+	assert.Equal(t, "/path/to/my job.godoit", posted.Path)
+	assert.Equal(t, "0 30 * * * *", posted.Spec)
+	assert.Equal(t, 60, posted.Timeout)
+	assert.Equal(t, 2, posted.Priority)
+
+	job.Name = "failing job"
+	result = executor.Execute(job)
+	assert.EqualError(t, result.Error, "HTTP status 500")
+	assert.Equal(t, 500, result.HTTPStatus)
+}
+
+func TestHTTPExecutorTimeout(t *testing.T) {
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	clock := NewFakeClock(testStartTime)
+	executor, _ := NewExecutor("http", ExecutorOptions{URL: server.URL, Clock: clock})
+	results := make(chan RunResult)
+	go func() {
+		results <- executor.Execute(Job{Name: "my job", Timezone: time.UTC, Timeout: time.Minute})
+	}()
+	waitFor(t, func() bool { return clock.Timers() == 1 })
+	clock.Advance(time.Minute)
+	result := <-results
+	assert.True(t, result.TimedOut)
+	assert.Nil(t, result.Error)
+	assert.Equal(t, time.Minute, result.Duration)
+}
+
+func TestUnregisterExecutor(t *testing.T) {
+	RegisterExecutor("unregistered", newExecExecutor)
+	UnregisterExecutor("unregistered")
+	assert.False(t, IsExecutor("unregistered"))
+	assert.NotContains(t, Executors(), "unregistered")
+}
+
+func TestHTTPExecutorNeedsHttpURL(t *testing.T) {
+	_, err := NewExecutor("http", ExecutorOptions{URL: "file:///tmp/jobs"})
+	assert.EqualError(t, err, "Invalid job executor URL: 'file:///tmp/jobs'")
+}
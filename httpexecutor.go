@@ -0,0 +1,96 @@
+package godoit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// httpJob is the job metadata the http executor posts
+type httpJob struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Spec string `json:"spec"`
+	Timezone string `json:"timezone"`
+	Timeout int `json:"timeout"`
+	Priority int `json:"priority"`
+	Start string `json:"start"`
+	TriggerFile string `json:"triggerFile,omitempty"`
+}
+
+// newHTTPExecutor posts each job as JSON to the URL, the run succeeds if the
+// response has a 2xx status. The response body is written to the output.
+func newHTTPExecutor(options ExecutorOptions) (Executor, error) {
+	if options.URL == "" {
+		return nil, fmt.Errorf("Job executor URL is not defined")
+	}
+	target := os.ExpandEnv(options.URL)
+	if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("Invalid job executor URL: '%s'", options.URL)
+	}
+	output := options.Output
+	if output == nil {
+		output = ioutil.Discard
+	}
+	clock := options.Clock
+
+	return ExecutorFunc(func(job Job) RunResult {
+		result := RunResult{Start: clock.Now(), ExitCode: -1}
+		body, _ := json.Marshal(httpJob{
+			job.Name,
+			job.Filepath,
+			job.Spec,
+			job.Timezone.String(),
+			int(job.Timeout.Seconds()),
+			job.Priority,
+			result.Start.UTC().Format("20060102T15:04:05Z"),
+			job.TriggerFile})
+
+		log.Printf("Posting job %s (%s) to %s Timeout: %s", job.Name, job.Filepath, target, job.Timeout)
+		// The request is cancelled when the timeout on the clock passes
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		timedOut := make(chan bool, 1)
+		if job.Timeout > 0 {
+			go func() {
+				select {
+				case <-clock.After(job.Timeout):
+					timedOut <- true
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+		}
+		request, err := http.NewRequestWithContext(ctx, "POST", target, bytes.NewReader(body))
+		var response *http.Response
+		if err == nil {
+			request.Header.Set("Content-Type", "application/json")
+			response, err = http.DefaultClient.Do(request)
+		}
+		if err == nil {
+			io.Copy(output, response.Body)
+			response.Body.Close()
+			result.HTTPStatus = response.StatusCode
+			if response.StatusCode < 200 || response.StatusCode > 299 {
+				err = fmt.Errorf("HTTP status %d", response.StatusCode)
+			}
+		}
+		result.Duration = clock.Now().Sub(result.Start)
+
+		if err != nil && len(timedOut) > 0 {
+			log.Printf("Job %s timed out", job.Name)
+			result.TimedOut = true
+		} else if err != nil {
+			log.Printf("ERROR: Failed to post job %s to %s: %s", job.Name, target, err)
+			result.Error = err
+		}
+		return result
+	}), nil
+}
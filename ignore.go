@@ -0,0 +1,113 @@
+package godoit
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFilename is the file in a directory listing the files and directories
+// in it which are not scanned for jobs, one gitignore-like pattern per line.
+const IgnoreFilename = ".godoitignore"
+
+type ignorePattern struct {
+	pattern string
+	negate bool
+	directoryOnly bool
+}
+
+// IgnoreRules are the patterns of an ignore file, a later pattern overrides an
+// earlier one and a pattern starting with `!` includes what it matches again.
+type IgnoreRules []ignorePattern
+
+// ReadIgnoreFile returns the rules in the ignore file in the directory, there
+// are no rules if there is no ignore file.
+func ReadIgnoreFile(directory string) (IgnoreRules, error) {
+	rules := IgnoreRules{}
+	file, err := os.Open(filepath.Join(directory, IgnoreFilename))
+	if os.IsNotExist(err) {
+		return rules, nil
+	} else if err != nil {
+		return rules, fmt.Errorf("Unable to read %s", IgnoreFilename)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignorePattern{}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.directoryOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		rule.pattern = strings.TrimPrefix(line, "/")
+		if _, err := filepath.Match(rule.pattern, ""); err != nil || rule.pattern == "" {
+			return rules, fmt.Errorf("Invalid pattern '%s' in %s line %d", scanner.Text(), IgnoreFilename, i)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Ignored returns whether the file or directory with the name, in the
+// directory of the ignore file, is ignored
+func (rules IgnoreRules) Ignored(name string, isDir bool) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.directoryOnly && !isDir {
+			continue
+		}
+		if matched, _ := filepath.Match(rule.pattern, name); matched {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// excludedBy returns the exclude pattern matching the path, a pattern without
+// a `/` matches the last part of the path and a `**` part matches any number
+// of directories.
+func excludedBy(exclude []string, name string) (string, bool) {
+	for _, element := range exclude {
+		pattern := os.ExpandEnv(element)
+		if !strings.Contains(pattern, "/") {
+			if matched, _ := filepath.Match(pattern, path.Base(name)); matched {
+				return element, true
+			}
+		} else if matchParts(strings.Split(path.Clean(pattern), "/"), strings.Split(name, "/")) {
+			return element, true
+		}
+	}
+	return "", false
+}
+
+func matchParts(patterns, parts []string) bool {
+	if len(patterns) == 0 {
+		return len(parts) == 0
+	}
+	if patterns[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchParts(patterns[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	if matched, _ := filepath.Match(patterns[0], parts[0]); !matched {
+		return false
+	}
+	return matchParts(patterns[1:], parts[1:])
+}
@@ -0,0 +1,42 @@
+package godoit
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+// applyJitterParameter parses a jitter of the form '<duration> [random|host]'.
+// A host jitter is the same for every run of the job on a host but differs
+// between hosts and jobs.
+func applyJitterParameter(job *Job, value string) {
+	parts := strings.Fields(value)
+	valid := len(parts) == 1 || (len(parts) == 2 && (parts[1] == "random" || parts[1] == "host"))
+	if valid {
+		if d, err := time.ParseDuration(parts[0]); err == nil && d >= 0 {
+			job.Jitter = d
+			job.JitterStable = len(parts) == 2 && parts[1] == "host"
+			return
+		}
+	}
+	job.Errors = append(job.Errors, fmt.Sprintf("Invalid jitter: '%s'", value))
+}
+
+// jitterDelay returns a delay for a run of the job which is less than the jitter
+func jitterDelay(job Job) time.Duration {
+	if job.Jitter <= 0 {
+		return 0
+	}
+	if job.JitterStable {
+		hostname, _ := os.Hostname()
+		hash := fnv.New64a()
+		hash.Write([]byte(hostname))
+		hash.Write([]byte{0})
+		hash.Write([]byte(job.Name))
+		return time.Duration(hash.Sum64() % uint64(job.Jitter))
+	}
+	return time.Duration(rand.Int63n(int64(job.Jitter)))
+}
@@ -1,6 +1,9 @@
-package main
+package godoit
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
 	"path"
 	"path/filepath"
 	"strings"
@@ -11,76 +14,240 @@ import (
 	"log"
 	"github.com/robfig/cron"
 	"fmt"
+	"sort"
+	"reflect"
+	"strconv"
+	"syscall"
 )
 
 type Job struct {
 	Filepath string
+	// Spec is the first of the job's schedules
 	Spec string
+	Schedules []CronSpec
 	Timezone *time.Location
 	Name string
 	Timeout time.Duration
 	Enabled bool
 	Errors []string
+	ErrorLines []int
 	UpdateTime time.Time
+	Size int64
+	Hash string
+	User string
+	Group string
+	Credential *syscall.Credential
+	Limits ResourceLimits
+	Priority int
+	Jitter time.Duration
+	JitterStable bool
+	Executor string
+	DST string
+	Calendar string
+	CalendarPolicy string
+	// From and Until limit when the job runs, zero for no limit
+	From time.Time
+	Until time.Time
+	// MaxRuns is the number of times the job runs, 0 for no limit
+	MaxRuns int
+	Trigger FileTrigger
+	// TriggerFile is the file which triggered this run of the job, if a file
+	// triggered it
+	TriggerFile string
 }
 
+// JobDefaults holds parameter values, keyed by parameter name, which apply to a
+// job unless the job file specifies the parameter itself.
+type JobDefaults map[string]string
+
 var cronSpecRegex,_ = regexp.Compile(`\s*($|#|\w+\s*=|(x|\*|(?:[0-5]?\d)(?:(?:-|%|\,)(?:[0-5]?\d))?(?:,(?:[0-5]?\d)(?:(?:-|%|\,)(?:[0-5]?\d))?)*)\s+(x|\*|(?:[0-5]?\d)(?:(?:-|%|\,)(?:[0-5]?\d))?(?:,(?:[0-5]?\d)(?:(?:-|%|\,)(?:[0-5]?\d))?)*)\s+(x|\*|(?:[01]?\d|2[0-3])(?:(?:-|%|\,)(?:[01]?\d|2[0-3]))?(?:,(?:[01]?\d|2[0-3])(?:(?:-|%|\,)(?:[01]?\d|2[0-3]))?)*)\s+(x|\*|(?:0?[1-9]|[12]\d|3[01])(?:(?:-|%|\,)(?:0?[1-9]|[12]\d|3[01]))?(?:,(?:0?[1-9]|[12]\d|3[01])(?:(?:-|%|\,)(?:0?[1-9]|[12]\d|3[01]))?)*)\s+(x|\*|(?:[1-9]|1[012])(?:(?:-|%|\,)(?:[1-9]|1[012]))?(?:L|W)?(?:,(?:[1-9]|1[012])(?:(?:-|%|\,)(?:[1-9]|1[012]))?(?:L|W)?)*|x|\*|(?:JAN|FEB|MAR|APR|MAY|JUN|JUL|AUG|SEP|OCT|NOV|DEC)(?:(?:-)(?:JAN|FEB|MAR|APR|MAY|JUN|JUL|AUG|SEP|OCT|NOV|DEC))?(?:,(?:JAN|FEB|MAR|APR|MAY|JUN|JUL|AUG|SEP|OCT|NOV|DEC)(?:(?:-)(?:JAN|FEB|MAR|APR|MAY|JUN|JUL|AUG|SEP|OCT|NOV|DEC))?)*)\s+(x|\*|(?:[0-6])(?:(?:-|%|\,|#)(?:[0-6]))?(?:L)?(?:,(?:[0-6])(?:(?:-|%|\,|#)(?:[0-6]))?(?:L)?)*|x|\*|(?:MON|TUE|WED|THU|FRI|SAT|SUN)(?:(?:-)(?:MON|TUE|WED|THU|FRI|SAT|SUN))?(?:,(?:MON|TUE|WED|THU|FRI|SAT|SUN)(?:(?:-)(?:MON|TUE|WED|THU|FRI|SAT|SUN))?)*)(|\s)+(x|\*|(?:|\d{4})(?:(?:-|%|\,)(?:|\d{4}))?(?:,(?:|\d{4})(?:(?:-|%|\,)(?:|\d{4}))?)*)) (.*)\.godoit`)
 var noTimeout = time.Second * 0
 var GodoitFileSuffix = ".godoit"
 var godoitCommentPrefix = "#:godoit "
 
 
-func ParseJobFile(directory, filename string) *Job {
+// ParseJobFile reads the job from the file in the directory, applying the
+// defaults to any parameters the file does not set. It returns nil if the
+// filename is not a job file, a job with errors is returned disabled.
+func ParseJobFile(directory, filename string, defaults JobDefaults) *Job {
 	jobPath := path.Join(directory, filename)
-	var cronspec string
-	var name string
-	enabled :=
-		!strings.HasPrefix(filename, "--") &&
-		!strings.HasPrefix(filename, "#")
+	job := &Job{
+		Filepath: filepath.Join(directory, filename),
+		Timezone: time.UTC,
+		Enabled: !strings.HasPrefix(filename, "--") && !strings.HasPrefix(filename, "#"),
+		Errors: make([]string, 0, 10),
+		ErrorLines: make([]int, 0, 10)}
 
 	if result := cronSpecRegex.FindStringSubmatch(filename); result != nil {
-		cronspec = strings.Replace(result[1], "x", "*", -1)
-		cronspec = strings.Replace(cronspec, "%", "/", -1)
-		name = strings.TrimSpace(result[10])
+		cronspec := strings.Replace(result[1], "x", "*", -1)
+		job.Spec = strings.Replace(cronspec, "%", "/", -1)
+		job.Name = strings.TrimSpace(result[10])
 	} else if strings.HasSuffix(filename, GodoitFileSuffix) {
-		name = strings.TrimSuffix(filename, GodoitFileSuffix)
+		job.Name = strings.TrimSuffix(filename, GodoitFileSuffix)
 	} else {
 		return nil
 	}
 
-	cronspec, timeout, timezone, errors, updateTime := parseJobParameters(jobPath, cronspec)
+	parseJobParameters(jobPath, job, defaults)
+	resolveCredential(job)
+
+	if job.Spec == "" && job.Trigger.Glob == "" {
+		job.Errors = append(job.Errors, "Missing cronspec")
+	} else if job.Spec != "" && len(job.Schedules) == 0 {
+		job.Schedules = []CronSpec{{Spec: job.Spec}}
+	}
+
+	checkOwnership(job)
+	job.setErrorLines(0)
+
+	if len(job.Errors) > 0 {
+		job.Enabled = false
+		log.Printf("Errors parsing job %s: %v", jobPath, job.Errors)
+	}
+	return job
+}
+
+// fileHash returns the SHA-256 of the contents of the file, or an empty string
+// if the file cannot be read
+func fileHash(filePath string) string {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// sameDefinition returns whether the jobs are scheduled and run in the same
+// way, ignoring when and how the job file changed
+func sameDefinition(a, b Job) bool {
+	if a.Timezone.String() != b.Timezone.String() {
+		return false
+	}
+	if fmt.Sprint(a.Schedules) != fmt.Sprint(b.Schedules) {
+		return false
+	}
+	a.Timezone, b.Timezone = nil, nil
+	a.Schedules, b.Schedules = nil, nil
+	a.UpdateTime, b.UpdateTime = time.Time{}, time.Time{}
+	a.Size, b.Size = 0, 0
+	a.Hash, b.Hash = "", ""
+	return reflect.DeepEqual(a, b)
+}
 
-	if cronspec == "" {
-		errors = append(errors, "Missing cronspec")
+// Schedule returns the schedule of the job's cronspecs, each in its timezone or
+// the job's timezone, applying the job's daylight saving policy and calendar if
+// it has them and only running between from and until
+func (job Job) Schedule() (cron.Schedule, error) {
+	schedule, err := job.cronSchedule()
+	if err != nil {
+		return nil, err
+	}
+	if job.Calendar != "" {
+		schedule = calendarSchedule{schedule, job.Calendar, job.CalendarPolicy}
 	}
+	if !job.From.IsZero() || !job.Until.IsZero() {
+		schedule = dateRangeSchedule{schedule, job.From, job.Until}
+	}
+	return schedule, nil
+}
 
-	if len(errors) > 0 {
-		enabled = false
-		log.Printf("Errors parsing job %s: %v", jobPath, errors)
+func (job Job) cronSchedule() (cron.Schedule, error) {
+	specs := job.Schedules
+	if len(specs) == 0 && job.Spec != "" {
+		specs = []CronSpec{{Spec: job.Spec}}
+	}
+	schedules := mergedSchedule{}
+	for _, spec := range specs {
+		if isRunOnceSpec(spec.Spec) {
+			continue
+		}
+		schedule, err := cron.Parse(spec.Spec)
+		if err != nil {
+			return nil, err
+		}
+		location := spec.Timezone
+		if location == nil {
+			location = job.Timezone
+		}
+		if _, ok := schedule.(*cron.SpecSchedule); ok && job.DST != "" {
+			schedules = append(schedules, dstSchedule{schedule, location, job.DST})
+		} else {
+			schedules = append(schedules, locationSchedule{schedule, location})
+		}
 	}
+	if len(schedules) == 0 {
+		return neverSchedule{}, nil
+	} else if len(schedules) == 1 {
+		return schedules[0], nil
+	}
+	return schedules, nil
+}
 
-	return &Job{
-		filepath.Join(directory, filename),
-		cronspec,
-		timezone,
-		name,
-		timeout,
-		enabled,
-		errors,
-		updateTime}
+// runsAt returns whether one of the job's cronspecs is the spec, such as @startup
+func (job Job) runsAt(spec string) bool {
+	for _, cronSpec := range job.Schedules {
+		if cronSpec.Spec == spec {
+			return true
+		}
+	}
+	return false
 }
 
-func parseJobParameters(jobPath, cronspec string) (string, time.Duration, *time.Location, []string, time.Time) {
-	timeout := 0 * time.Second
-	timezone := time.UTC
-	var updateTime time.Time
-	errors := make ([]string,0,10)
+// Next returns the first time after t the job is scheduled to run, or the zero
+// time if the job is disabled or its cronspec is invalid.
+func (job Job) Next(t time.Time) time.Time {
+	next, _ := job.NextWithNote(t)
+	return next
+}
+
+// NextWithNote returns the first time after t the job is scheduled to run as
+// Next does, and a note describing how the daylight saving policy changed the
+// run, or how the job's calendar moved or skipped it, if they did.
+func (job Job) NextWithNote(t time.Time) (time.Time, string) {
+	if !job.Enabled {
+		return time.Time{}, ""
+	}
+	schedule, err := job.Schedule()
+	if err != nil {
+		return time.Time{}, ""
+	}
+	return nextWithNote(schedule, t)
+}
+
+// CheckJobDefaults returns the errors from applying the defaults to an empty job
+func CheckJobDefaults(defaults JobDefaults) []string {
+	job := &Job{Timezone: time.UTC}
+	parseJobParameters(os.DevNull, job, defaults)
+	resolveCredential(job)
+	return job.Errors
+}
+
+func parseJobParameters(jobPath string, job *Job, defaults JobDefaults) {
+	// Apply the defaults first so the job file can override them
+	params := make([]string, 0, len(defaults))
+	for param := range defaults {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+	for _, param := range params {
+		if param != "cronspec" {
+			applyJobParameter(job, param, defaults[param])
+		}
+	}
+	job.setErrorLines(0)
 
 	if file, err := os.Open(jobPath); err == nil {
 		defer file.Close()
 		if info, err := file.Stat() ; err == nil {
-			updateTime = info.ModTime()
+			job.UpdateTime = info.ModTime()
+			job.Size = info.Size()
 		}
+		job.Hash = fileHash(jobPath)
 
 		// create a new scanner and read the file line by line
 		scanner := bufio.NewScanner(file)
@@ -98,38 +265,90 @@ func parseJobParameters(jobPath, cronspec string) (string, time.Duration, *time.
 				line = strings.TrimSpace(line)
 				parts := strings.SplitN(line," ",2)
 				if len(parts) == 2 {
-					param := parts[0]
-					value := parts[1]
-					if param == "cronspec" {
-						if cronspec != "" {
-							errors = append(errors, "Cronspec in filename and as comment")
-						}
-						if _, err := cron.Parse(value); err == nil {
-							cronspec = value
-						} else {
-							errors = append(errors, fmt.Sprintf("Invalid cronspec: '%s'", value))
-						}
-					} else if param == "timeout" {
-						if d, err := time.ParseDuration(value); err == nil {
-							timeout = d
-						} else {
-							errors = append(errors, fmt.Sprintf("Invalid timeout: '%s'", value))
-						}
-					} else if param == "timezone" {
-						if l, err := time.LoadLocation(value); err == nil {
-							timezone = l
-						} else {
-							errors = append(errors, fmt.Sprintf("Invalid timezone: '%s'", value))
-						}
-					}
-
+					applyJobParameter(job, parts[0], parts[1])
 				} else {
-					errors = append(errors, fmt.Sprintf("Invalid parameter '%s'", line))
+					job.Errors = append(job.Errors, fmt.Sprintf("Invalid parameter '%s'", line))
 				}
+				job.setErrorLines(i)
 			}
 		}
 	} else {
-		errors = append(errors, "Unable to open file to parse parameters")
+		job.Errors = append(job.Errors, "Unable to open file to parse parameters")
+	}
+	job.From = inTimezone(job.From, job.Timezone)
+	job.Until = inTimezone(job.Until, job.Timezone)
+}
+
+// setErrorLines records the line number of any errors added since it was last
+// called, 0 for errors which are not from a line of the job file.
+func (job *Job) setErrorLines(line int) {
+	for len(job.ErrorLines) < len(job.Errors) {
+		job.ErrorLines = append(job.ErrorLines, line)
 	}
-	return cronspec, timeout, timezone, errors, updateTime
-}
\ No newline at end of file
+}
+
+func applyJobParameter(job *Job, param, value string) {
+	if param == "cronspec" {
+		if job.Spec != "" && len(job.Schedules) == 0 {
+			job.Errors = append(job.Errors, "Cronspec in filename and as comment")
+		}
+		if spec, err := parseCronSpec(value); err == nil {
+			if job.Spec == "" {
+				job.Spec = spec.Spec
+			}
+			job.Schedules = append(job.Schedules, spec)
+		} else {
+			job.Errors = append(job.Errors, err.Error())
+		}
+	} else if param == "timeout" {
+		if d, err := time.ParseDuration(value); err == nil {
+			job.Timeout = d
+		} else {
+			job.Errors = append(job.Errors, fmt.Sprintf("Invalid timeout: '%s'", value))
+		}
+	} else if param == "timezone" {
+		if l, err := time.LoadLocation(value); err == nil {
+			job.Timezone = l
+		} else {
+			job.Errors = append(job.Errors, fmt.Sprintf("Invalid timezone: '%s'", value))
+		}
+	} else if param == "user" {
+		job.User = value
+	} else if param == "group" {
+		job.Group = value
+	} else if param == "priority" {
+		if priority, err := strconv.Atoi(value); err == nil {
+			job.Priority = priority
+		} else {
+			job.Errors = append(job.Errors, fmt.Sprintf("Invalid priority: '%s'", value))
+		}
+	} else if param == "from" || param == "until" {
+		applyDateParameter(job, param, value)
+	} else if param == "maxruns" {
+		if maxRuns, err := strconv.Atoi(value); err == nil && maxRuns > 0 {
+			job.MaxRuns = maxRuns
+		} else {
+			job.Errors = append(job.Errors, fmt.Sprintf("Invalid maxruns: '%s'", value))
+		}
+	} else if param == "trigger" {
+		applyTriggerParameter(job, value)
+	} else if param == "jitter" {
+		applyJitterParameter(job, value)
+	} else if param == "dst" {
+		if isDSTPolicy(value) {
+			job.DST = value
+		} else {
+			job.Errors = append(job.Errors, fmt.Sprintf("Invalid dst: '%s'", value))
+		}
+	} else if param == "calendar" {
+		applyCalendarParameter(job, value)
+	} else if param == "executor" {
+		if IsExecutor(value) {
+			job.Executor = value
+		} else {
+			job.Errors = append(job.Errors, fmt.Sprintf("Invalid executor: '%s'", value))
+		}
+	} else if isLimitParameter(param) {
+		applyLimitParameter(job, param, value)
+	}
+}
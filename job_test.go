@@ -1,4 +1,4 @@
-package main
+package godoit
 
 import (
 	"testing"
@@ -7,6 +7,10 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"os/user"
+	"strconv"
+	"fmt"
+	"os/exec"
 )
 
 func TestPathMatching(t *testing.T) {
@@ -146,6 +150,33 @@ func TestDuplicateCronSpec(t *testing.T) {
 	})
 }
 
+func TestMultipleCronSpecs(t *testing.T) {
+	withDir(func(dir string) {
+		job := createTestJob(
+			dir,
+			"report.godoit",
+			"#:godoit cronspec 0 0 6 * * 1-5",
+			"#:godoit cronspec 0 0 10 * * 0,6 America/New_York",
+			"#:godoit timezone Europe/London")
+		assert.Equal(t, 0, len(job.Errors))
+		assert.Equal(t, "0 0 6 * * 1-5", job.Spec)
+		assert.Equal(t, 2, len(job.Schedules))
+		assert.Equal(t, "0 0 10 * * 0,6 America/New_York", job.Schedules[1].String())
+
+		// Friday 6 January 2017 at 06:00 in London then Saturday at 10:00 in New York
+		next := job.Next(time.Date(2017, 1, 5, 12, 0, 0, 0, time.UTC))
+		assert.Equal(t, time.Date(2017, 1, 6, 6, 0, 0, 0, time.UTC), next.UTC())
+		assert.Equal(t, "Europe/London", next.Location().String())
+		next = job.Next(next)
+		assert.Equal(t, time.Date(2017, 1, 7, 15, 0, 0, 0, time.UTC), next.UTC())
+		assert.Equal(t, "America/New_York", next.Location().String())
+
+		job = createTestJob(dir, "report.godoit", "#:godoit cronspec 0 0 6 * * 1-5", "#:godoit cronspec 0 0 10 * * 0,6 Mars/Olympus")
+		assert.Equal(t, []string{"Invalid cronspec: '0 0 10 * * 0,6 Mars/Olympus'"}, job.Errors)
+		assert.False(t, job.Enabled)
+	})
+}
+
 func TestUnknownParam(t *testing.T) {
 	withDir(func(dir string) {
 		job := createTestJob(dir, "0 30 * * * * test.godoit","#:godoit blahh")
@@ -154,6 +185,453 @@ func TestUnknownParam(t *testing.T) {
 	})
 }
 
+func TestUserAndGroupParams(t *testing.T) {
+	withDir(func(dir string) {
+		job := createTestJob(
+			dir,
+			"0 30 * * * * test.godoit",
+			"#:godoit user root",
+			"#:godoit group root")
+		assert.NotNil(t, job, "Failed to parse job")
+		assert.Equal(t, "root", job.User)
+		assert.Equal(t, "root", job.Group)
+		assert.Equal(t, uint32(0), job.Credential.Uid)
+		assert.Equal(t, uint32(0), job.Credential.Gid)
+		assert.Equal(t, true, job.Enabled)
+	})
+}
+
+func TestInvalidUserAndGroup(t *testing.T) {
+	withDir(func(dir string) {
+		job := createTestJob(dir, "0 30 * * * * test.godoit", "#:godoit user nosuchuser")
+		assert.NotNil(t, job, "Failed to parse job")
+		assert.Equal(t, "Invalid user: 'nosuchuser'", job.Errors[0])
+		assert.Equal(t, false, job.Enabled)
+
+		job = createTestJob(dir, "0 30 * * * * test.godoit", "#:godoit group nosuchgroup")
+		assert.Equal(t, "Invalid group: 'nosuchgroup'", job.Errors[0])
+		assert.Equal(t, false, job.Enabled)
+	})
+}
+
+func TestDefaultUser(t *testing.T) {
+	withDir(func(dir string) {
+		createTestJob(dir, "0 30 * * * * test.godoit")
+		job := ParseJobFile(dir, "0 30 * * * * test.godoit", JobDefaults{"user": "root"})
+		assert.Equal(t, "root", job.User)
+		assert.Equal(t, uint32(0), job.Credential.Uid)
+
+		createTestJob(dir, "0 30 * * * * test.godoit", "#:godoit user nobody")
+		job = ParseJobFile(dir, "0 30 * * * * test.godoit", JobDefaults{"user": "root"})
+		assert.Equal(t, "nobody", job.User)
+	})
+}
+
+func TestJobOwnedByUserMustRunAsOwner(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Changing file ownership requires root")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("No nobody user")
+	}
+	uid, _ := strconv.Atoi(nobody.Uid)
+	withDir(func(dir string) {
+		createTestJob(dir, "0 30 * * * * test.godoit")
+		os.Chown(path.Join(dir, "0 30 * * * * test.godoit"), uid, 0)
+
+		job := ParseJobFile(dir, "0 30 * * * * test.godoit", JobDefaults{})
+		assert.Equal(t, "Job owned by 'nobody' cannot run as 'root'", job.Errors[0])
+		assert.Equal(t, false, job.Enabled)
+
+		job = ParseJobFile(dir, "0 30 * * * * test.godoit", JobDefaults{"user": "nobody"})
+		assert.Equal(t, 0, len(job.Errors))
+		assert.Equal(t, true, job.Enabled)
+
+		// Nor with a group the owner is not a member of
+		job = ParseJobFile(dir, "0 30 * * * * test.godoit", JobDefaults{"user": "nobody", "group": "root"})
+		assert.Equal(t, "Job owned by 'nobody' cannot run as group 'root'", job.Errors[0])
+		assert.Equal(t, false, job.Enabled)
+	})
+}
+
+func TestLimitParams(t *testing.T) {
+	withDir(func(dir string) {
+		job := createTestJob(
+			dir,
+			"0 30 * * * * test.godoit",
+			"#:godoit rlimit-as 2G",
+			"#:godoit rlimit-nofile 1024",
+			"#:godoit rlimit-cpu 10m",
+			"#:godoit rlimit-core unlimited",
+			"#:godoit nice 10",
+			"#:godoit ionice best-effort 6")
+		assert.NotNil(t, job, "Failed to parse job")
+		assert.Equal(t, 0, len(job.Errors))
+		assert.Equal(t, uint64(2 << 30), job.Limits.Rlimits["as"])
+		assert.Equal(t, uint64(1024), job.Limits.Rlimits["nofile"])
+		assert.Equal(t, uint64(600), job.Limits.Rlimits["cpu"])
+		assert.Equal(t, unlimited, job.Limits.Rlimits["core"])
+		assert.Equal(t, 10, job.Limits.Nice)
+		assert.Equal(t, "best-effort", job.Limits.IOClass)
+		assert.Equal(t, 6, job.Limits.IOLevel)
+	})
+}
+
+func TestCgroupLimitParams(t *testing.T) {
+	withDir(func(dir string) {
+		job := createTestJob(dir, "0 30 * * * * test.godoit", "#:godoit memory 512M", "#:godoit cpu 150%")
+		assert.Equal(t, 0, len(job.Errors))
+		assert.Equal(t, uint64(512 << 20), job.Limits.Memory)
+		assert.Equal(t, 150, job.Limits.CPU)
+
+		job = createTestJob(dir, "0 30 * * * * test.godoit", "#:godoit memory 0", "#:godoit cpu half")
+		assert.Equal(t, "Invalid memory: '0'", job.Errors[0])
+		assert.Equal(t, "Invalid cpu: 'half'", job.Errors[1])
+	})
+}
+
+func TestDefaultLimits(t *testing.T) {
+	withDir(func(dir string) {
+		createTestJob(dir, "0 30 * * * * test.godoit", "#:godoit rlimit-nofile 1024")
+		job := ParseJobFile(
+			dir,
+			"0 30 * * * * test.godoit",
+			JobDefaults{"rlimit-nofile": "64", "rlimit-core": "0", "ionice": "idle"})
+		assert.Equal(t, 0, len(job.Errors))
+		assert.Equal(t, uint64(1024), job.Limits.Rlimits["nofile"])
+		assert.Equal(t, uint64(0), job.Limits.Rlimits["core"])
+		assert.Equal(t, "idle", job.Limits.IOClass)
+
+		// The level is not raised above the default when it is not given
+		job = ParseJobFile(dir, "0 30 * * * * test.godoit", JobDefaults{"ionice": "best-effort"})
+		assert.Equal(t, "best-effort", job.Limits.IOClass)
+		assert.Equal(t, 4, job.Limits.IOLevel)
+	})
+}
+
+func TestInvalidLimitParams(t *testing.T) {
+	withDir(func(dir string) {
+		job := createTestJob(
+			dir,
+			"0 30 * * * * test.godoit",
+			"#:godoit rlimit-as lots",
+			"#:godoit rlimit-cpu 1ms",
+			"#:godoit nice 20",
+			"#:godoit ionice idle 3",
+			"#:godoit memory 20000000T")
+		assert.NotNil(t, job, "Failed to parse job")
+		assert.Equal(t, "Invalid rlimit-as: 'lots'", job.Errors[0])
+		assert.Equal(t, "Invalid rlimit-cpu: '1ms'", job.Errors[1])
+		assert.Equal(t, "Invalid nice: '20'", job.Errors[2])
+		assert.Equal(t, "Invalid ionice: 'idle 3'", job.Errors[3])
+		assert.Equal(t, "Invalid memory: '20000000T'", job.Errors[4])
+		assert.Equal(t, false, job.Enabled)
+	})
+}
+
+func TestLimitCommandNotInstalled(t *testing.T) {
+	defer func() { lookLimitCommand = exec.LookPath }()
+	lookLimitCommand = func(command string) (string, error) {
+		return "", fmt.Errorf("%s not found", command)
+	}
+	withDir(func(dir string) {
+		job := createTestJob(dir, "0 30 * * * * test.godoit", "#:godoit nice 10", "#:godoit rlimit-nofile 64", "#:godoit memory 1G")
+		assert.Equal(t, []string{"Unable to apply nice, nice is not installed", "Unable to apply rlimit-nofile, prlimit is not installed"}, job.Errors)
+		assert.Equal(t, false, job.Enabled)
+	})
+}
+
+func TestJitterParams(t *testing.T) {
+	withDir(func(dir string) {
+		job := createTestJob(dir, "0 30 * * * * test.godoit", "#:godoit jitter 5m")
+		assert.Equal(t, 0, len(job.Errors))
+		assert.Equal(t, 5 * time.Minute, job.Jitter)
+		assert.Equal(t, false, job.JitterStable)
+
+		job = createTestJob(dir, "0 30 * * * * test.godoit", "#:godoit jitter 90s host")
+		assert.Equal(t, 90 * time.Second, job.Jitter)
+		assert.Equal(t, true, job.JitterStable)
+
+		job = createTestJob(dir, "0 30 * * * * test.godoit", "#:godoit jitter 5m always")
+		assert.Equal(t, "Invalid jitter: '5m always'", job.Errors[0])
+	})
+}
+
+func TestExecutorParam(t *testing.T) {
+	withDir(func(dir string) {
+		job := createTestJob(dir, "0 30 * * * * test.godoit")
+		assert.Equal(t, "", job.Executor)
+
+		job = createTestJob(dir, "0 30 * * * * test.godoit", "#:godoit executor http")
+		assert.Equal(t, 0, len(job.Errors))
+		assert.Equal(t, "http", job.Executor)
+
+		job = createTestJob(dir, "0 30 * * * * test.godoit", "#:godoit executor ssh")
+		assert.Equal(t, "Invalid executor: 'ssh'", job.Errors[0])
+		assert.Equal(t, false, job.Enabled)
+	})
+}
+
+func TestDSTParam(t *testing.T) {
+	withDir(func(dir string) {
+		job := createTestJob(dir, "0 30 1 * * * test.godoit", "#:godoit dst once")
+		assert.Equal(t, 0, len(job.Errors))
+		assert.Equal(t, DSTOnce, job.DST)
+
+		job = createTestJob(dir, "0 30 1 * * * test.godoit", "#:godoit dst twice")
+		assert.Equal(t, "Invalid dst: 'twice'", job.Errors[0])
+		assert.Equal(t, false, job.Enabled)
+	})
+}
+
+func TestDSTClocksGoForward(t *testing.T) {
+	london, _ := time.LoadLocation("Europe/London")
+	job := Job{Spec: "0 30 1 * * *", Timezone: london, Enabled: true}
+	before := time.Date(2017, 3, 25, 12, 0, 0, 0, time.UTC)
+
+	// Without a policy 01:30 is skipped
+	assert.Equal(t, time.Date(2017, 3, 27, 0, 30, 0, 0, time.UTC), job.Next(before).UTC())
+
+	job.DST = DSTSkip
+	next, note := job.NextWithNote(before)
+	assert.Equal(t, time.Date(2017, 3, 27, 0, 30, 0, 0, time.UTC), next.UTC())
+	assert.Equal(t, "skipped 2017-03-26 01:30:00 does not exist in Europe/London", note)
+
+	job.DST = DSTOnce
+	next, note = job.NextWithNote(before)
+	assert.Equal(t, time.Date(2017, 3, 26, 1, 30, 0, 0, time.UTC), next.UTC())
+	assert.Equal(t, "2017-03-26 01:30:00 does not exist in Europe/London, run at 02:30:00 BST", note)
+	next, note = job.NextWithNote(next)
+	assert.Equal(t, time.Date(2017, 3, 27, 0, 30, 0, 0, time.UTC), next.UTC())
+	assert.Equal(t, "", note)
+}
+
+func TestDSTClocksGoBack(t *testing.T) {
+	london, _ := time.LoadLocation("Europe/London")
+	job := Job{Spec: "0 30 1 * * *", Timezone: london, Enabled: true}
+	before := time.Date(2017, 10, 28, 12, 0, 0, 0, time.UTC)
+	runs := func() []time.Time {
+		runs := []time.Time{}
+		for next := job.Next(before); len(runs) < 3; next = job.Next(next) {
+			runs = append(runs, next.UTC())
+		}
+		return runs
+	}
+	first := time.Date(2017, 10, 29, 0, 30, 0, 0, time.UTC)
+	second := time.Date(2017, 10, 29, 1, 30, 0, 0, time.UTC)
+	nextDay := time.Date(2017, 10, 30, 1, 30, 0, 0, time.UTC)
+
+	job.DST = DSTSkip
+	assert.Equal(t, nextDay, runs()[0])
+	job.DST = DSTOnce
+	assert.Equal(t, []time.Time{first, nextDay, nextDay.Add(24 * time.Hour)}, runs())
+	job.DST = DSTBoth
+	assert.Equal(t, []time.Time{first, second, nextDay}, runs())
+	_, note := job.NextWithNote(first)
+	assert.Equal(t, "2017-10-29 01:30:00 occurs twice in Europe/London, run at both", note)
+
+	// An hourly job runs once in the hour which is repeated
+	job = Job{Spec: "0 0 * * * *", Timezone: london, Enabled: true, DST: DSTOnce}
+	before = time.Date(2017, 10, 28, 23, 30, 0, 0, time.UTC)
+	assert.Equal(
+		t,
+		[]time.Time{
+			time.Date(2017, 10, 29, 0, 0, 0, 0, time.UTC),
+			time.Date(2017, 10, 29, 2, 0, 0, 0, time.UTC),
+			time.Date(2017, 10, 29, 3, 0, 0, 0, time.UTC)},
+		runs())
+}
+
+func TestLoadCalendars(t *testing.T) {
+	withDir(func(dir string) {
+		createTestJob(dir, "holidays.dates", "# Christmas", "2017-12-25", "2017-12-26 Boxing Day")
+		createTestJob(dir, "office.ics",
+			"BEGIN:VCALENDAR",
+			"BEGIN:VEVENT",
+			"DTSTART;VALUE=DATE:20171225",
+			"DTEND;VALUE=DATE:20171227",
+			"END:VEVENT",
+			"BEGIN:VEVENT",
+			"DTSTART;TZID=America/New_York:20180101T000000",
+			"END:VEVENT",
+			"BEGIN:VEVENT",
+			"DTSTART:20180103T090000",
+			"DTEND:20180104T120000",
+			"END:VEVENT",
+			"END:VCALENDAR")
+		defer UnregisterCalendar("holidays")
+		defer UnregisterCalendar("office")
+		names, err := LoadCalendars(dir)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"holidays", "office"}, names)
+		assert.True(t, IsCalendar("holidays"))
+		assert.False(t, IsCalendar("missing"))
+
+		holidays, _ := findCalendar("holidays")
+		assert.Equal(t, Calendar{"2017-12-25": true, "2017-12-26": true}, holidays)
+		office, _ := findCalendar("office")
+		assert.Equal(
+			t,
+			Calendar{"2017-12-25": true, "2017-12-26": true, "2018-01-01": true, "2018-01-03": true, "2018-01-04": true},
+			office)
+
+		createTestJob(dir, "bad.dates", "25/12/2017")
+		_, err = LoadCalendars(dir)
+		assert.Equal(t, "Invalid date '25/12/2017' in " + path.Join(dir, "bad.dates") + " line 1", err.Error())
+
+		UnregisterCalendar("holidays")
+		assert.False(t, IsCalendar("holidays"))
+	})
+}
+
+func TestICSTimesInUTC(t *testing.T) {
+	withDir(func(dir string) {
+		createTestJob(dir, "late.ics",
+			"BEGIN:VCALENDAR",
+			"BEGIN:VEVENT",
+			"DTSTART:20180101T233000Z",
+			"END:VEVENT",
+			"END:VCALENDAR")
+		calendar, err := ReadCalendar(path.Join(dir, "late.ics"))
+		assert.Nil(t, err)
+		date := time.Date(2018, 1, 1, 23, 30, 0, 0, time.UTC).In(time.Local).Format("2006-01-02")
+		assert.Equal(t, Calendar{date: true}, calendar)
+
+		createTestJob(dir, "bad.ics", "BEGIN:VEVENT", "DTSTART:20180101T2330")
+		_, err = ReadCalendar(path.Join(dir, "bad.ics"))
+		assert.Equal(t, "Invalid DTSTART '20180101T2330' in " + path.Join(dir, "bad.ics") + " line 2", err.Error())
+	})
+}
+
+func TestCalendarParam(t *testing.T) {
+	RegisterCalendar("test-holidays", Calendar{})
+	defer UnregisterCalendar("test-holidays")
+	withDir(func(dir string) {
+		job := createTestJob(dir, "0 0 9 * * * test.godoit", "#:godoit calendar test-holidays")
+		assert.Equal(t, 0, len(job.Errors))
+		assert.Equal(t, "test-holidays", job.Calendar)
+		assert.Equal(t, CalendarSkip, job.CalendarPolicy)
+
+		job = createTestJob(dir, "0 0 9 * * * test.godoit", "#:godoit calendar test-holidays next-business-day")
+		assert.Equal(t, CalendarNextBusinessDay, job.CalendarPolicy)
+
+		job = createTestJob(dir, "0 0 9 * * * test.godoit", "#:godoit calendar test-holidays tomorrow")
+		assert.Equal(t, "Invalid calendar: 'test-holidays tomorrow'", job.Errors[0])
+		assert.Equal(t, false, job.Enabled)
+
+		job = createTestJob(dir, "0 0 9 * * * test.godoit", "#:godoit calendar missing")
+		assert.Equal(t, "Invalid calendar: 'missing'", job.Errors[0])
+	})
+}
+
+func TestCalendarPolicies(t *testing.T) {
+	RegisterCalendar("test-christmas", Calendar{"2017-12-25": true, "2017-12-26": true})
+	defer UnregisterCalendar("test-christmas")
+	job := Job{Spec: "0 0 9 * * *", Timezone: time.UTC, Enabled: true, Calendar: "test-christmas"}
+	christmasEve := time.Date(2017, 12, 24, 12, 0, 0, 0, time.UTC)
+
+	job.CalendarPolicy = CalendarSkip
+	next, note := job.NextWithNote(christmasEve)
+	assert.Equal(t, time.Date(2017, 12, 27, 9, 0, 0, 0, time.UTC), next)
+	assert.Equal(t, "skipped 2017-12-25 is in calendar test-christmas", note)
+
+	// Both holidays move to the 27th, which runs once
+	job.CalendarPolicy = CalendarNextBusinessDay
+	next, note = job.NextWithNote(christmasEve)
+	assert.Equal(t, time.Date(2017, 12, 27, 9, 0, 0, 0, time.UTC), next)
+	assert.Equal(t, "moved from 2017-12-25 is in calendar test-christmas", note)
+	assert.Equal(t, time.Date(2017, 12, 28, 9, 0, 0, 0, time.UTC), job.Next(next))
+
+	// Christmas is a Monday, so a weekly run moves back to the Friday
+	job.Spec = "0 0 9 * * MON"
+	job.CalendarPolicy = CalendarPreviousBusinessDay
+	next, note = job.NextWithNote(time.Date(2017, 12, 21, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2017, 12, 22, 9, 0, 0, 0, time.UTC), next)
+	assert.Equal(t, "moved from 2017-12-25 is in calendar test-christmas", note)
+	assert.Equal(t, time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC), job.Next(next))
+}
+
+func TestDateRangeParams(t *testing.T) {
+	withDir(func(dir string) {
+		london, _ := time.LoadLocation("Europe/London")
+		job := createTestJob(dir, "0 0 2 * * * test.godoit",
+			"#:godoit from 2017-06-01 12:30",
+			"#:godoit until 2017-06-30",
+			"#:godoit timezone Europe/London",
+			"#:godoit maxruns 3")
+		assert.Equal(t, 0, len(job.Errors))
+		assert.Equal(t, time.Date(2017, 6, 1, 12, 30, 0, 0, london), job.From)
+		assert.Equal(t, time.Date(2017, 6, 30, 23, 59, 59, 0, london), job.Until)
+		assert.Equal(t, 3, job.MaxRuns)
+
+		job = createTestJob(dir, "0 0 2 * * * test.godoit", "#:godoit from June", "#:godoit maxruns 0")
+		assert.Equal(t, []string{"Invalid from: 'June'", "Invalid maxruns: '0'"}, job.Errors)
+		assert.Equal(t, false, job.Enabled)
+	})
+}
+
+func TestDateRangeLimitsRuns(t *testing.T) {
+	job := Job{
+		Spec: "0 0 2 * * *",
+		Timezone: time.UTC,
+		Enabled: true,
+		From: time.Date(2017, 6, 1, 12, 30, 0, 0, time.UTC),
+		Until: time.Date(2017, 6, 3, 23, 59, 59, 0, time.UTC)}
+	assert.Equal(t, time.Date(2017, 6, 2, 2, 0, 0, 0, time.UTC), job.Next(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, time.Date(2017, 6, 3, 2, 0, 0, 0, time.UTC), job.Next(time.Date(2017, 6, 2, 2, 0, 0, 0, time.UTC)))
+	assert.True(t, job.Next(time.Date(2017, 6, 3, 2, 0, 0, 0, time.UTC)).IsZero())
+}
+
+func TestRunOnceCronSpecs(t *testing.T) {
+	withDir(func(dir string) {
+		job := createTestJob(dir, "init.godoit", "#:godoit cronspec @startup")
+		assert.Equal(t, 0, len(job.Errors))
+		assert.Equal(t, StartupSpec, job.Spec)
+		assert.True(t, job.runsAt(StartupSpec))
+		assert.False(t, job.runsAt(OnceSpec))
+		assert.True(t, job.Next(time.Now()).IsZero())
+
+		job = createTestJob(dir, "init.godoit", "#:godoit cronspec @once", "#:godoit cronspec 0 0 6 * * *")
+		assert.Equal(t, 0, len(job.Errors))
+		assert.True(t, job.runsAt(OnceSpec))
+		assert.Equal(t, time.Date(2017, 1, 1, 6, 0, 0, 0, time.UTC), job.Next(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+		job = createTestJob(dir, "init.godoit", "#:godoit cronspec @once Europe/London")
+		assert.Equal(t, "Invalid cronspec: '@once Europe/London'", job.Errors[0])
+	})
+}
+
+func TestTriggerParam(t *testing.T) {
+	withDir(func(dir string) {
+		job := createTestJob(dir, "load.godoit", "#:godoit trigger file in/*.csv")
+		assert.Equal(t, 0, len(job.Errors))
+		assert.Equal(t, FileTrigger{"in/*.csv", time.Second, 0}, job.Trigger)
+		assert.Equal(t, path.Join(dir, "in/*.csv"), triggerPattern(*job))
+
+		job = createTestJob(dir, "0 0 6 * * * load.godoit", "#:godoit trigger file /data/*.csv stable 30s debounce 5s")
+		assert.Equal(t, 0, len(job.Errors))
+		assert.Equal(t, FileTrigger{"/data/*.csv", 5 * time.Second, 30 * time.Second}, job.Trigger)
+		assert.Equal(t, "/data/*.csv", triggerPattern(*job))
+
+		for _, value := range []string{"dir /data", "file /data/[", "file /data/*.csv stable", "file /data/*.csv wait 5s"} {
+			job = createTestJob(dir, "load.godoit", "#:godoit trigger " + value)
+			assert.Equal(t, "Invalid trigger: '" + value + "'", job.Errors[0])
+			assert.Equal(t, false, job.Enabled)
+		}
+	})
+}
+
+func TestHostJitterIsStable(t *testing.T) {
+	job := Job{Name: "job name", Jitter: time.Hour, JitterStable: true}
+	delay := jitterDelay(job)
+	assert.True(t, delay >= 0 && delay < time.Hour)
+	assert.Equal(t, delay, jitterDelay(job))
+
+	job.Name = "other job"
+	assert.NotEqual(t, delay, jitterDelay(job))
+}
+
 type withDirFunc func(dir string)
 
 func withDir(aFunc withDirFunc) {
@@ -169,6 +647,6 @@ func createTestJob(dir string, file string, lines ...string) *Job {
 		f.WriteString("\n")
 	}
 	f.Close()
-	return ParseJobFile(dir, file)
+	return ParseJobFile(dir, file, JobDefaults{})
 }
 
@@ -1,48 +1,126 @@
-package main
+package godoit
 
 import (
 	"log"
-	"github.com/robfig/cron"
 	"path/filepath"
 	"io/ioutil"
 	"time"
 	"os"
 	"strings"
+	"sort"
+	"sync"
+	"github.com/robfig/cron"
 )
 
 type JobSet struct {
-	executor JobExecutor
+	executor Executor
 	directory string
+	defaults JobDefaults
+	directoryDefaults JobDefaults
+	directoryDefaultsError error
+	directoryDefaultsHash string
+	exclude []string
+	excluded []string
+	blackouts []BlackoutWindow
 	jobs map [string]Job
-	crons map [string]*cron.Cron
+	scheduler *jobScheduler
+	ownScheduler bool
+	scheduled map [string]Job
+	clock Clock
+	results map [string]RunResult
+	runs map [string]int
+	started map [string]bool
+	once map [string]bool
+	runState *runState
+	triggers map [string]*triggerWatcher
+	delays map [string]time.Duration
+	running sync.WaitGroup
+	lock sync.Mutex
 }
 
-func NewJobSet(executor JobExecutor, directory string) *JobSet {
-	return &JobSet{executor, directory, make(map[string]Job), make(map[string]*cron.Cron)}
+// JobSet schedules the jobs in a single directory
+func NewJobSet(executor Executor, directory string, defaults JobDefaults, clock Clock) *JobSet {
+	return &JobSet{
+		executor: executor,
+		directory: directory,
+		defaults: defaults,
+		jobs: make(map[string]Job),
+		scheduled: make(map[string]Job),
+		clock: clock,
+		results: make(map[string]RunResult),
+		runs: make(map[string]int),
+		started: make(map[string]bool),
+		once: make(map[string]bool),
+		runState: loadRunState(""),
+		triggers: make(map[string]*triggerWatcher),
+		delays: make(map[string]time.Duration)}
 }
 
+// Stop stops scheduling the jobs in the directory, runs which have started
+// are not stopped
 func (jobSet *JobSet) Stop() {
-	for key, cron := range jobSet.crons {
+	if len(jobSet.scheduled) > 0 {
 		log.Printf("  Stopping jobs in directory, %s", jobSet.directory)
-		cron.Stop()
-		delete(jobSet.crons, key)
+	}
+	for filename, job := range jobSet.scheduled {
+		jobSet.unscheduleJob(filename, job)
+	}
+	if jobSet.ownScheduler {
+		jobSet.scheduler.Stop()
+		jobSet.scheduler = nil
+		jobSet.ownScheduler = false
 	}
 }
 
+// Scan updates the jobs from the files in the directory and reschedules the
+// jobs which changed, returning whether any changed
 func (jobSet *JobSet) Scan() bool {
+	updated := jobSet.ScanJobs()
+	jobSet.schedule()
+	return updated
+}
+
+// ScanJobs updates the jobs from the files in the directory without scheduling
+// them, returning whether any jobs were added, updated or removed.
+func (jobSet *JobSet) ScanJobs() bool {
 	updated := false
+	defaultsChanged := jobSet.scanDefaults()
+	ignoreRules, err := ReadIgnoreFile(jobSet.directory)
+	if err != nil {
+		log.Printf("Errors parsing %s in %s: %s", IgnoreFilename, jobSet.directory, err)
+	}
 
 	// Scan for any new jobs
 	files, _ := ioutil.ReadDir(jobSet.directory)
 	foundFiles := make(map[string]bool)
+	excluded := []string{}
+	reasons := make(map[string]string)
 	for _,file := range files {
 		filename := file.Name()
+		if reason := jobSet.excludes(filename, ignoreRules); isGodoitFile(file) && reason != "" {
+			jobPath := filepath.Join(jobSet.directory, filename)
+			excluded = append(excluded, jobPath)
+			reasons[jobPath] = reason
+			continue
+		}
 		foundFiles[filename] = true
-		if isGodoitFile(file) && shouldParseJob(file, jobSet) {
-			job := ParseJobFile(jobSet.directory, filename)
+		if isGodoitFile(file) && (defaultsChanged || shouldParseJob(file, jobSet)) {
+			job := ParseJobFile(jobSet.directory, filename, jobSet.directoryDefaults)
+			if job != nil && jobSet.directoryDefaultsError != nil {
+				job.Errors = append(job.Errors, jobSet.directoryDefaultsError.Error())
+				job.ErrorLines = append(job.ErrorLines, 0)
+				job.Enabled = false
+			}
 			if job != nil {
-				updated = true
+				previous, known := jobSet.jobs[filename]
 				jobSet.jobs[filename] = *job
+				if known && sameDefinition(previous, *job) {
+					continue
+				}
+				updated = true
+				jobSet.lock.Lock()
+				delete(jobSet.delays, filename)
+				jobSet.lock.Unlock()
 			}
 		}
 	}
@@ -52,67 +130,392 @@ func (jobSet *JobSet) Scan() bool {
 		if _,ok := foundFiles[filename]; ! ok {
 			updated = true
 			delete(jobSet.jobs,filename)
+			jobSet.lock.Lock()
+			delete(jobSet.results,filename)
+			delete(jobSet.runs,filename)
+			delete(jobSet.started,filename)
+			delete(jobSet.delays,filename)
+			jobSet.lock.Unlock()
 		}
 	}
+	logExcluded("job", jobSet.excluded, excluded, reasons)
+	jobSet.excluded = excluded
+	return updated
+}
 
-	// Setup the cron
-	if updated {
-		jobSet.setupCron()
+// excludes returns why the job file is excluded, it matches an exclude pattern
+// or is ignored by the ignore file in the directory, or an empty string if it
+// is not
+func (jobSet *JobSet) excludes(filename string, ignoreRules IgnoreRules) string {
+	jobPath := filepath.Join(jobSet.directory, filename)
+	if pattern, matched := excludedBy(jobSet.exclude, jobPath); matched {
+		return "matches " + pattern
+	} else if ignoreRules.Ignored(filename, false) {
+		return "ignored by " + filepath.Join(jobSet.directory, IgnoreFilename)
 	}
-	return updated
+	return ""
+}
+
+// scanDefaults reads the defaults file in the directory when it has been added,
+// changed or removed since the last scan, returning whether it was read. The
+// file is compared by the hash of its contents, like the job files.
+func (jobSet *JobSet) scanDefaults() bool {
+	hash := fileHash(filepath.Join(jobSet.directory, DefaultsFilename))
+	if jobSet.directoryDefaults != nil && hash == jobSet.directoryDefaultsHash {
+		return false
+	}
+	jobSet.directoryDefaultsHash = hash
+	jobSet.directoryDefaults, jobSet.directoryDefaultsError = DirectoryDefaults(jobSet.directory, jobSet.defaults)
+	if jobSet.directoryDefaultsError != nil {
+		log.Printf("Errors parsing %s in %s: %s", DefaultsFilename, jobSet.directory, jobSet.directoryDefaultsError)
+	}
+	return true
+}
+
+// SetExclude changes the patterns of the job files not to schedule, jobs which
+// match are removed by the next scan
+func (jobSet *JobSet) SetExclude(exclude []string) {
+	jobSet.exclude = exclude
+}
+
+// SetBlackouts changes the windows during which no jobs start, the scheduled
+// jobs are rescheduled to skip them
+func (jobSet *JobSet) SetBlackouts(blackouts []BlackoutWindow) {
+	jobSet.lock.Lock()
+	jobSet.blackouts = blackouts
+	jobSet.lock.Unlock()
+	for _, job := range jobSet.scheduled {
+		if err := jobSet.addJob(job); err != nil {
+			log.Printf("ERROR: Failed to reschedule job %s (%s), %s", job.Name, jobSet.directory, err)
+		}
+	}
+}
+
+// Excluded returns the job files excluded by the last scan
+func (jobSet *JobSet) Excluded() []string {
+	return jobSet.excluded
+}
+
+// Directory returns the directory the jobs are read from
+func (jobSet *JobSet) Directory() string {
+	return jobSet.directory
+}
+
+// Jobs returns the jobs in the directory ordered by filename
+func (jobSet *JobSet) Jobs() []Job {
+	filenames := make([]string, 0, len(jobSet.jobs))
+	for filename := range jobSet.jobs {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	jobs := make([]Job, len(filenames))
+	for i, filename := range filenames {
+		jobs[i] = jobSet.jobs[filename]
+	}
+	return jobs
 }
 
 func isGodoitFile(file os.FileInfo) bool {
 	return ! file.IsDir() && strings.HasSuffix(file.Name(), GodoitFileSuffix)
 }
 
+// shouldParseJob returns whether the job file is new or its contents changed.
+// The hash of the contents is always compared, as copying files can keep their
+// modification time and size while changing them.
 func shouldParseJob(file os.FileInfo, jobSet *JobSet) bool {
 	job,hasJob := jobSet.jobs[file.Name()]
-	if hasJob {
-		// If the job is know - parse if the modification time has changed
-		return job.UpdateTime != file.ModTime()
-	} else {
+	if !hasJob {
 		// If the job is not known - parse it
 		return true
 	}
-
+	if fileHash(job.Filepath) != job.Hash {
+		return true
+	}
+	// At most the modification time changed, remember it for the status
+	job.UpdateTime = file.ModTime()
+	job.Size = file.Size()
+	jobSet.jobs[file.Name()] = job
+	return false
 }
 
-func (jobSet *JobSet) setupCron() {
-	jobSet.Stop()
+// schedule adds, updates and removes jobs in the schedulers to match the jobs
+// found by the last scan. Jobs which have not changed stay scheduled as they
+// are, so changing one job does not affect when the others run. Jobs which
+// will not run again are removed.
+func (jobSet *JobSet) schedule() {
+	now := jobSet.clock.Now()
+	for filename, scheduled := range jobSet.scheduled {
+		job, ok := jobSet.jobs[filename]
+		if reason := jobSet.inactive(filename, job, now); ok && reason != "" {
+			log.Printf("  Unscheduling job %s (%s), %s", scheduled.Name, jobSet.directory, reason)
+			jobSet.unscheduleJob(filename, scheduled)
+		} else if !ok || !job.Enabled || !sameDefinition(scheduled, job) || (job.MaxRuns > 0 && job.Hash != scheduled.Hash) {
+			// The runs of a job with maxruns are counted for each version of
+			// the job file, so a new version is scheduled to count its own
+			log.Printf("  Unscheduling job %s (%s)", scheduled.Name, jobSet.directory)
+			jobSet.unscheduleJob(filename, scheduled)
+		}
+	}
 
+	for filename, job := range jobSet.jobs {
+		if _, ok := jobSet.scheduled[filename]; ok || !job.Enabled || jobSet.inactive(filename, job, now) != "" {
+			continue
+		}
+		log.Printf("  Scheduling job %s (%s): %s (%s)", job.Name, jobSet.directory, strings.Join(cronSpecStrings(job.Schedules), ", "), job.Timezone.String())
+		if err := jobSet.addJob(job); err != nil {
+			log.Printf("ERROR: Failed to schedule job %s (%s), %s", job.Name, jobSet.directory, err)
+			continue
+		}
+		jobSet.scheduled[filename] = job
+		if job.Trigger.Glob != "" {
+			log.Printf("  Watching for %s to trigger job %s (%s)", triggerPattern(job), job.Name, jobSet.directory)
+			jobSet.triggers[filename] = startTriggerWatcher(triggerPattern(job), job.Trigger, jobSet.clock, jobSet.triggerRun(job))
+		}
+	}
 
-	log.Printf("  Starting crons for %s", jobSet.directory)
-	for _,job := range jobSet.jobs  {
-		if job.Enabled {
-			addJob(jobSet.cronForLocation(job.Timezone), jobSet.executor, job)
+	for filename, job := range jobSet.jobs {
+		if job.Enabled && jobSet.inactive(filename, job, now) == "" {
+			jobSet.startRunOnceJob(filename, job, now)
 		}
 	}
-	for timezone, cron := range jobSet.crons {
-		log.Printf("    Timezone: %s", timezone)
-		cron.Start()
+}
+
+// triggerRun returns the function which runs the job when a file triggers it
+func (jobSet *JobSet) triggerRun(job Job) func(path string) {
+	return func(path string) {
+		triggered := job
+		triggered.TriggerFile = path
+		log.Printf("Job %s (%s) triggered by %s", job.Name, jobSet.directory, path)
+		jobSet.startRun(triggered, "")
 	}
 }
 
-func (jobSet *JobSet) cronForLocation(location *time.Location) *cron.Cron {
-	timezoneName := location.String()
-	if locationCron, ok := jobSet.crons[timezoneName]; ok {
-		return locationCron
-	} else {
-		var newCron = cron.NewWithLocation(location)
-		jobSet.crons[timezoneName] = newCron
-		return newCron
+// unscheduleJob stops running the job on its schedule and trigger
+func (jobSet *JobSet) unscheduleJob(filename string, job Job) {
+	jobSet.scheduler.Remove(job.Filepath)
+	delete(jobSet.scheduled, filename)
+	if watcher, ok := jobSet.triggers[filename]; ok {
+		watcher.Stop()
+		delete(jobSet.triggers, filename)
+	}
+}
+
+// startRunOnceJob runs a job with an @startup cronspec the first time the job
+// set finds it, and a job with an @once cronspec if this version of the job
+// file has never run. The @once run is recorded when it starts, so it is
+// started again if godoit stops before then.
+func (jobSet *JobSet) startRunOnceJob(filename string, job Job, now time.Time) {
+	if job.runsAt(StartupSpec) && !jobSet.started[filename] {
+		jobSet.started[filename] = true
+		log.Printf("  Starting job %s (%s) at startup", job.Name, jobSet.directory)
+		jobSet.startRun(job, StartupSpec)
+	}
+	if !job.runsAt(OnceSpec) || jobSet.once[onceKey(job)] || jobSet.runState.hasRun(job) {
+		return
+	}
+	jobSet.once[onceKey(job)] = true
+	log.Printf("  Starting job %s (%s) once", job.Name, jobSet.directory)
+	jobSet.startRun(job, OnceSpec)
+}
+
+// useScheduler schedules the jobs with a scheduler shared with other job sets,
+// which must be set before the jobs are scheduled
+func (jobSet *JobSet) useScheduler(scheduler *jobScheduler) {
+	jobSet.scheduler = scheduler
+}
+
+// jobSchedule returns the schedule of the job, skipping runs during the
+// blackout windows
+func (jobSet *JobSet) jobSchedule(job Job) (cron.Schedule, error) {
+	schedule, err := job.Schedule()
+	if err != nil || len(jobSet.blackouts) == 0 {
+		return schedule, err
+	}
+	return blackoutSchedule{schedule, jobSet.blackouts}, nil
+}
+
+// NextRun returns the first time after t the job is scheduled to run, skipping
+// the blackout windows, and a note on how the daylight saving policy, calendar
+// or blackouts changed the run if they did. It returns the zero time if the
+// job is disabled or its cronspec is invalid.
+func (jobSet *JobSet) NextRun(job Job, t time.Time) (time.Time, string) {
+	if !job.Enabled {
+		return time.Time{}, ""
+	}
+	schedule, err := jobSet.jobSchedule(job)
+	if err != nil {
+		return time.Time{}, ""
 	}
+	return nextWithNote(schedule, t)
 }
 
+// useRunState remembers the @once jobs which have run and the runs of jobs
+// with maxruns in a state shared with other job sets and saved to a file,
+// which must be set before the jobs are scheduled
+func (jobSet *JobSet) useRunState(state *runState) {
+	jobSet.runState = state
+}
+
+func (jobSet *JobSet) addJob(job Job) error {
+	schedule, err := jobSet.jobSchedule(job)
+	if err != nil {
+		return err
+	}
+	if jobSet.scheduler == nil {
+		jobSet.scheduler = newJobScheduler(jobSet.clock)
+		jobSet.ownScheduler = true
+		jobSet.scheduler.Start()
+	}
+	jobSet.scheduler.Set(job.Filepath, schedule, func() {jobSet.startRun(job, "")})
+	return nil
+}
+
+// inactive returns why an enabled job will not run again, or an empty string
+// if it will
+func (jobSet *JobSet) inactive(filename string, job Job, now time.Time) string {
+	return inactiveReason(job, jobSet.runCount(filename, job), now)
+}
+
+// countRun counts a run of the job, returning false if it has already run
+// maxruns times. The runs of a job with maxruns are counted in the run state,
+// so they are kept when godoit restarts.
+func (jobSet *JobSet) countRun(job Job) bool {
+	if job.MaxRuns > 0 {
+		return jobSet.runState.countRun(job)
+	}
+	jobSet.lock.Lock()
+	defer jobSet.lock.Unlock()
+	jobSet.runs[filepath.Base(job.Filepath)]++
+	return true
+}
 
-func addJob(cron *cron.Cron, executor JobExecutor, job Job) {
-	cron.AddFunc(job.Spec, func() {runJob(executor, job)})
+// startRun runs the job in the background, runOnce is the @startup or @once
+// cronspec the run is for or empty for a scheduled or triggered run
+func (jobSet *JobSet) startRun(job Job, runOnce string) {
+	jobSet.running.Add(1)
+	go func() {
+		defer jobSet.running.Done()
+		jobSet.runJob(job, runOnce)
+	}()
 }
 
-func runJob(executor JobExecutor, job Job) {
+func (jobSet *JobSet) runJob(job Job, runOnce string) {
+	delay := jobSet.nextDelay(job)
+	if delay > 0 {
+		log.Printf("Delaying job %s (%s) by %s", job.Name, filepath.Dir(job.Filepath), delay)
+		jobSet.clock.Sleep(delay)
+	}
+	// Scheduled runs skip the blackout windows, but a delay may end in one.
+	// Jobs which run once start when they are found and triggered runs when a
+	// file changes, so they wait for the end of the window instead.
+	for {
+		now := jobSet.clock.Now()
+		window, ok := blackoutWindowContaining(jobSet.currentBlackouts(), now)
+		if !ok {
+			break
+		}
+		end := window.end(now)
+		if (runOnce == "" && job.TriggerFile == "") || end.IsZero() {
+			log.Printf("Skipping job %s (%s), in blackout %s", job.Name, filepath.Dir(job.Filepath), window)
+			return
+		}
+		log.Printf("Deferring job %s (%s) until %s, in blackout %s", job.Name, filepath.Dir(job.Filepath), end.Format("2006-01-02 15:04:05 MST"), window)
+		jobSet.clock.Sleep(end.Sub(now))
+	}
+	if runOnce == OnceSpec && jobSet.runState.hasRun(job) {
+		return
+	}
+	if !jobSet.countRun(job) {
+		// The job is unscheduled by the next scan
+		log.Printf("Not running job %s (%s), it has run maxruns %d times", job.Name, filepath.Dir(job.Filepath), job.MaxRuns)
+		return
+	}
+	if runOnce == OnceSpec {
+		jobSet.runState.record(job, jobSet.clock.Now())
+	}
 	log.Printf("Running job %s (%s) Timeout: %s", job.Name, filepath.Dir(job.Filepath), timeoutString(job.Timeout))
-	executor(job.Name, job.Filepath, job.Timeout)
+	result := jobSet.executor.Execute(job)
+	result.Jitter = delay
+	log.Printf(
+		"Job %s (%s) finished in %s (Exit code: %d, Timed out: %t, Peak memory: %d, CPU: %s)",
+		job.Name,
+		filepath.Dir(job.Filepath),
+		result.Duration,
+		result.ExitCode,
+		result.TimedOut,
+		result.PeakMemory,
+		result.CPUTime)
+
+	jobSet.lock.Lock()
+	defer jobSet.lock.Unlock()
+	jobSet.results[filepath.Base(job.Filepath)] = result
+}
+
+// currentBlackouts returns the blackout windows in use
+func (jobSet *JobSet) currentBlackouts() []BlackoutWindow {
+	jobSet.lock.Lock()
+	defer jobSet.lock.Unlock()
+	return jobSet.blackouts
+}
+
+// nextDelay returns the jitter delay for this run of the job and chooses the
+// delay for the following run, so the next run time is known in advance.
+func (jobSet *JobSet) nextDelay(job Job) time.Duration {
+	jobSet.lock.Lock()
+	defer jobSet.lock.Unlock()
+	filename := filepath.Base(job.Filepath)
+	delay, ok := jobSet.delays[filename]
+	if !ok {
+		delay = jitterDelay(job)
+	}
+	jobSet.delays[filename] = jitterDelay(job)
+	return delay
+}
+
+// nextRun returns when the job will next start including the jitter delay, or
+// the zero time if the job is not scheduled or will not run again. Jobs which
+// have not been scheduled, for example in a preview, are given the next time
+// after now.
+func (jobSet *JobSet) nextRun(filename string, job Job, now time.Time) time.Time {
+	if jobSet.inactive(filename, job, now) != "" {
+		return time.Time{}
+	}
+	next, _ := jobSet.NextRun(job, now)
+	if _, ok := jobSet.scheduled[filename]; ok {
+		next = jobSet.scheduler.Next(job.Filepath)
+	}
+	if next.IsZero() {
+		return next
+	}
+
+	jobSet.lock.Lock()
+	defer jobSet.lock.Unlock()
+	delay, ok := jobSet.delays[filename]
+	if !ok {
+		delay = jitterDelay(job)
+		jobSet.delays[filename] = delay
+	}
+	return next.Add(delay)
+}
+
+// runCount returns the number of times the job has started since it was found,
+// or for a job with maxruns the number of times this version of the job file
+// has started
+func (jobSet *JobSet) runCount(filename string, job Job) int {
+	if job.MaxRuns > 0 {
+		return jobSet.runState.count(job)
+	}
+	jobSet.lock.Lock()
+	defer jobSet.lock.Unlock()
+	return jobSet.runs[filename]
+}
+
+// lastResult returns the result of the last run of the job, if it has run
+func (jobSet *JobSet) lastResult(filename string) (RunResult, bool) {
+	jobSet.lock.Lock()
+	defer jobSet.lock.Unlock()
+	result, ok := jobSet.results[filename]
+	return result, ok
 }
 
 func (jobSet *JobSet) printJobs() {
@@ -123,7 +526,7 @@ func (jobSet *JobSet) printJobs() {
 	for _,job := range jobSet.jobs {
 		log.Printf(
 			"  %s (%s): %s (Timeout: %s, Enabled: %t)",
-			job.Spec,
+			strings.Join(cronSpecStrings(job.Schedules), ", "),
 			job.Timezone.String(),
 			job.Name,
 			timeoutString(job.Timeout),
@@ -1,4 +1,4 @@
-package main
+package godoit
 
 import (
 	"testing"
@@ -14,17 +14,19 @@ import (
 var executions = make(map [string]int)
 var lock sync.RWMutex
 
-var executor = func(name, path string, timeout time.Duration) {
+var executor = ExecutorFunc(func(job Job) RunResult {
 	lock.Lock()
 	defer  lock.Unlock()
 
+	name, path := job.Name, job.Filepath
 	log.Printf("Executing %s: %s", name, path)
 	if _,ok := executions[name]; ok {
 		executions[name] = executions[name]+1
 	} else {
 		executions[name] = 1
 	}
-}
+	return RunResult{Start: time.Now()}
+})
 
 func TestScanEmptyDir(t *testing.T) {
 	withJobSet(func(jobSet *JobSet) {
@@ -39,9 +41,9 @@ func TestScanDirWithCommentedOutFile(t *testing.T) {
 		// Commened out job does not create a job
 		createJob(jobSet, "--* * * * * * TestScanDirWithCommentedOutFile.godoit")
 		assertRescanUpdates(t, jobSet, true)
-		time.Sleep(time.Second * 3)
+		advance(jobSet, time.Second * 3)
 		assertRescanUpdates(t, jobSet, false)
-		assertNoExecutions(t, "TestScanDirWithCommentedOutFile")
+		assertNoExecutions(t, jobSet, "TestScanDirWithCommentedOutFile")
 	})
 }
 
@@ -50,9 +52,9 @@ func TestScanDirWithCommentedOutFile2(t *testing.T) {
 		// Commened out job does not create a job
 		createJob(jobSet, "#* * * * * * TestScanDirWithCommentedOutFile2.godoit")
 		assertRescanUpdates(t, jobSet, true)
-		time.Sleep(time.Second * 3)
+		advance(jobSet, time.Second * 3)
 		assertRescanUpdates(t, jobSet, false)
-		assertNoExecutions(t, "TestScanDirWithCommentedOutFile2")
+		assertNoExecutions(t, jobSet, "TestScanDirWithCommentedOutFile2")
 	})
 }
 
@@ -62,9 +64,9 @@ func TestScanCreate1SecondJob(t *testing.T) {
 		createJob(jobSet, "* * * * * * TestScanCreate1SecondJob.godoit")
 		assertRescanUpdates(t, jobSet, true)
 		assertJobCount(t, jobSet, 1)
-		time.Sleep(time.Second * 6)
+		advance(jobSet, time.Second * 6)
 		assertRescanUpdates(t, jobSet, false)
-		assertExecutions(t, "TestScanCreate1SecondJob", 5)
+		assertExecutions(t, jobSet, "TestScanCreate1SecondJob", 6)
 	})
 }
 
@@ -73,9 +75,9 @@ func TestScanCreateJobWithTimeZone(t *testing.T) {
 		// Commened out job does not create a job
 		createJob(jobSet, "TestScanCreateJobWithTimeZone.godoit","#:godoit cronspec * * * * *","#:godoit timezone Europe/Paris")
 		assertRescanUpdates(t, jobSet, true)
-		time.Sleep(time.Second * 6)
+		advance(jobSet, time.Second * 6)
 		assertRescanUpdates(t, jobSet, false)
-		assertExecutions(t, "TestScanCreateJobWithTimeZone", 5)
+		assertExecutions(t, jobSet, "TestScanCreateJobWithTimeZone", 6)
 	})
 }
 
@@ -87,12 +89,12 @@ func TestScanCreateJobWithTimeZoneAndUTCJob(t *testing.T) {
 		createJob(jobSet, "bbTestScanCreateJobWithTimeZone_utc.godoit","#:godoit cronspec * * * * *")
 		createJob(jobSet, "yyTestScanCreateJobWithTimeZone.godoit","#:godoit cronspec * * * * *","#:godoit timezone Europe/Paris")
 		assertRescanUpdates(t, jobSet, true)
-		time.Sleep(time.Second * 6)
+		advance(jobSet, time.Second * 6)
 		assertRescanUpdates(t, jobSet, false)
-		assertExecutions(t, "zzTestScanCreateJobWithTimeZone", 5)
-		assertExecutions(t, "aaTestScanCreateJobWithTimeZone_utc", 5)
-		assertExecutions(t, "yyTestScanCreateJobWithTimeZone", 5)
-		assertExecutions(t, "bbTestScanCreateJobWithTimeZone_utc", 5)
+		assertExecutions(t, jobSet, "zzTestScanCreateJobWithTimeZone", 6)
+		assertExecutions(t, jobSet, "aaTestScanCreateJobWithTimeZone_utc", 6)
+		assertExecutions(t, jobSet, "yyTestScanCreateJobWithTimeZone", 6)
+		assertExecutions(t, jobSet, "bbTestScanCreateJobWithTimeZone_utc", 6)
 	})
 }
 
@@ -101,9 +103,9 @@ func TestScanCreateJobWithTimeout(t *testing.T) {
 		// Commened out job does not create a job
 		createJob(jobSet, "TestScanCreateJobWithTimeout.godoit","#:godoit cronspec * * * * *","#:godoit timeout 3s")
 		assertRescanUpdates(t, jobSet, true)
-		time.Sleep(time.Second * 6)
+		advance(jobSet, time.Second * 6)
 		assertRescanUpdates(t, jobSet, false)
-		assertExecutions(t, "TestScanCreateJobWithTimeout", 5)
+		assertExecutions(t, jobSet, "TestScanCreateJobWithTimeout", 6)
 	})
 }
 
@@ -113,9 +115,9 @@ func TestScanCreateJobWithSpecInFile(t *testing.T) {
 		createJob(jobSet, "TestScanCreateJobWithSpecInFile.godoit","#:godoit cronspec * * * * * *")
 		assertRescanUpdates(t, jobSet, true)
 		assertJobCount(t, jobSet, 1)
-		time.Sleep(time.Second * 6)
+		advance(jobSet, time.Second * 6)
 		assertRescanUpdates(t, jobSet, false)
-		assertExecutions(t, "TestScanCreateJobWithSpecInFile", 5)
+		assertExecutions(t, jobSet, "TestScanCreateJobWithSpecInFile", 6)
 	})
 }
 
@@ -125,14 +127,15 @@ func TestReScanJobWithUpdatedSpecInFile(t *testing.T) {
 		createJob(jobSet, "TestReScanJobWithUpdatedSpecInFile.godoit","#:godoit cronspec 0 0 12 * * *")
 		assertRescanUpdates(t, jobSet, true)
 		assertJobCount(t, jobSet, 1)
-		time.Sleep(time.Second * 3)
-		// Should generate no executions - or 1 if we run right on 12:00!
+		advance(jobSet, time.Second * 3)
+		// Should generate no executions
 		assertRescanUpdates(t, jobSet, false)
+		assertNoExecutions(t, jobSet, "TestReScanJobWithUpdatedSpecInFile")
 		// Now update the file
 		createJob(jobSet, "TestReScanJobWithUpdatedSpecInFile.godoit","#:godoit cronspec * * * * * *")
 		assertRescanUpdates(t, jobSet, true)
-		time.Sleep(time.Second * 6)
-		assertExecutions(t, "TestReScanJobWithUpdatedSpecInFile", 6)
+		advance(jobSet, time.Second * 6)
+		assertExecutions(t, jobSet, "TestReScanJobWithUpdatedSpecInFile", 6)
 	})
 }
 
@@ -141,25 +144,170 @@ func TestScanCreateJobWithInvalidSpecInFile(t *testing.T) {
 		// Commened out job does not create a job
 		createJob(jobSet, "TestScanCreateJobWithInvalidSpecInFile.godoit","#:godoit cronspec * * * *")
 		assertRescanUpdates(t, jobSet, true)
-		time.Sleep(time.Second * 3)
+		advance(jobSet, time.Second * 3)
+		assertRescanUpdates(t, jobSet, false)
+		assertNoExecutions(t, jobSet, "TestScanCreateJobWithInvalidSpecInFile")
+	})
+}
+
+
+func TestScanAppliesDirectoryDefaults(t *testing.T) {
+	withJobSet(func(jobSet *JobSet) {
+		createJob(jobSet, DefaultsFilename, "# Defaults for the jobs", "timezone Europe/London", "timeout 5m")
+		createJob(jobSet, "0 30 1 * * * job1.godoit")
+		createJob(jobSet, "0 30 1 * * * job2.godoit", "#:godoit timeout 1m")
+		assertRescanUpdates(t, jobSet, true)
+		assert.Equal(t, "Europe/London", jobSet.jobs["0 30 1 * * * job1.godoit"].Timezone.String())
+		assert.Equal(t, 5 * time.Minute, jobSet.jobs["0 30 1 * * * job1.godoit"].Timeout)
+		assert.Equal(t, time.Minute, jobSet.jobs["0 30 1 * * * job2.godoit"].Timeout)
+		assertRescanUpdates(t, jobSet, false)
+
+		// Changing the defaults re-parses the jobs, even if the modification
+		// time is kept
+		info, _ := os.Stat(path.Join(jobSet.directory, DefaultsFilename))
+		createJob(jobSet, DefaultsFilename, "timezone America/New_York")
+		os.Chtimes(path.Join(jobSet.directory, DefaultsFilename), time.Now(), info.ModTime())
+		assertRescanUpdates(t, jobSet, true)
+		assert.Equal(t, "America/New_York", jobSet.jobs["0 30 1 * * * job1.godoit"].Timezone.String())
+		assert.Equal(t, time.Duration(0), jobSet.jobs["0 30 1 * * * job1.godoit"].Timeout)
+
+		removeJob(t, jobSet, DefaultsFilename)
+		assertRescanUpdates(t, jobSet, true)
+		assert.Equal(t, "UTC", jobSet.jobs["0 30 1 * * * job1.godoit"].Timezone.String())
+	})
+}
+
+func TestInvalidDirectoryDefaultsDisableJobs(t *testing.T) {
+	withJobSet(func(jobSet *JobSet) {
+		createJob(jobSet, DefaultsFilename, "timezone Europe/London", "timeout")
+		createJob(jobSet, "0 30 1 * * * job1.godoit")
+		jobSet.Scan()
+		job := jobSet.jobs["0 30 1 * * * job1.godoit"]
+		assert.Equal(t, []string{"Invalid parameter 'timeout' in .godoit-defaults line 2"}, job.Errors)
+		assert.False(t, job.Enabled)
+	})
+}
+
+func TestExcludedJobsAreNotScheduled(t *testing.T) {
+	withJobSet(func(jobSet *JobSet) {
+		jobSet.SetExclude([]string{"*.old.godoit"})
+		createJob(jobSet, "* * * * * * job1.godoit")
+		createJob(jobSet, "* * * * * * job2.old.godoit")
+		createJob(jobSet, "* * * * * * job3.godoit")
+		assertRescanUpdates(t, jobSet, true)
+		assertJobCount(t, jobSet, 2)
+		assert.Equal(t, []string{path.Join(jobSet.directory, "* * * * * * job2.old.godoit")}, jobSet.Excluded())
+
+		// Ignoring a scheduled job removes it
+		createJob(jobSet, IgnoreFilename, "# Not ready yet", "*job3*")
+		assertRescanUpdates(t, jobSet, true)
+		assertJobCount(t, jobSet, 1)
+		assert.Equal(t, 2, len(jobSet.Excluded()))
+	})
+}
+
+func TestScanDetectsChangedContent(t *testing.T) {
+	withJobSet(func(jobSet *JobSet) {
+		filename := "0 30 1 * * * job1.godoit"
+		jobPath := path.Join(jobSet.directory, filename)
+		createJob(jobSet, filename, "#:godoit timeout 1m", "echo hello")
+		assertRescanUpdates(t, jobSet, true)
+		modTime := jobSet.jobs[filename].UpdateTime
+
+		// Touching the file does not change the job
+		os.Chtimes(jobPath, time.Now(), modTime.Add(time.Minute))
+		assertRescanUpdates(t, jobSet, false)
+		assert.Equal(t, modTime.Add(time.Minute), jobSet.jobs[filename].UpdateTime)
+
+		// Changes which keep the modification time and size are found
+		createJob(jobSet, filename, "#:godoit timeout 2m", "echo hello")
+		os.Chtimes(jobPath, time.Now(), modTime.Add(time.Minute))
+		assertRescanUpdates(t, jobSet, true)
+		assert.Equal(t, 2 * time.Minute, jobSet.jobs[filename].Timeout)
+		createJob(jobSet, filename, "#:godoit timeout 10m", "echo hello")
+		os.Chtimes(jobPath, time.Now(), modTime.Add(time.Minute))
+		assertRescanUpdates(t, jobSet, true)
+		assert.Equal(t, 10 * time.Minute, jobSet.jobs[filename].Timeout)
+
+		// Changes to the script which do not change the job are not updates
+		createJob(jobSet, filename, "#:godoit timeout 10m", "echo goodbye")
 		assertRescanUpdates(t, jobSet, false)
-		assertNoExecutions(t, "TestScanCreateJobWithInvalidSpecInFile")
+		assert.Equal(t, fileHash(jobPath), jobSet.jobs[filename].Hash)
+	})
+}
+
+func TestChangingAJobDoesNotRescheduleOthers(t *testing.T) {
+	withJobSet(func(jobSet *JobSet) {
+		createJob(jobSet, "0%10 * * * * * TestUnchangedJob.godoit")
+		createJob(jobSet, "* * * * * * TestChangedJob.godoit")
+		createJob(jobSet, "* * * * * * TestChangedTimezoneJob.godoit")
+		assertRescanUpdates(t, jobSet, true)
+		advance(jobSet, time.Second * 5)
+		assertExecutions(t, jobSet, "TestChangedJob", 5)
+		assertNoExecutions(t, jobSet, "TestUnchangedJob")
+
+		// Change, move and remove the other jobs part way through the interval
+		createJob(jobSet, "* * * * * * TestChangedJob.godoit", "#:godoit timeout 1m")
+		createJob(jobSet, "* * * * * * TestChangedTimezoneJob.godoit", "#:godoit timezone Europe/London")
+		assertRescanUpdates(t, jobSet, true)
+		assert.Equal(t, 3, jobSet.scheduler.Len())
+		advance(jobSet, time.Second * 5)
+		assertExecutions(t, jobSet, "TestUnchangedJob", 1)
+		assertExecutions(t, jobSet, "TestChangedJob", 10)
+		assertExecutions(t, jobSet, "TestChangedTimezoneJob", 10)
+
+		removeJob(t, jobSet, "* * * * * * TestChangedJob.godoit")
+		removeJob(t, jobSet, "* * * * * * TestChangedTimezoneJob.godoit")
+		assertRescanUpdates(t, jobSet, true)
+		assert.Equal(t, 1, jobSet.scheduler.Len())
+		advance(jobSet, time.Second * 10)
+		assertExecutions(t, jobSet, "TestUnchangedJob", 2)
+		assertExecutions(t, jobSet, "TestChangedJob", 10)
 	})
 }
 
+func TestJobSetsShareScheduler(t *testing.T) {
+	withJobSet(func(jobSet *JobSet) {
+		withDir(func(dir string) {
+			scheduler := newJobScheduler(jobSet.clock)
+			scheduler.Start()
+			defer scheduler.Stop()
+			other := NewJobSet(executor, dir, JobDefaults{}, jobSet.clock)
+			jobSet.useScheduler(scheduler)
+			other.useScheduler(scheduler)
+
+			createJob(jobSet, "0 30 1 * * * TestSharedJob1.godoit")
+			createTestJob(dir, "0 30 1 * * * TestSharedJob1.godoit", "#:godoit timezone America/New_York")
+			jobSet.Scan()
+			other.Scan()
+			assert.Equal(t, 2, scheduler.Len())
+			assert.Equal(
+				t,
+				time.Date(2017, 1, 2, 1, 30, 0, 0, time.UTC),
+				jobSet.nextRun("0 30 1 * * * TestSharedJob1.godoit", jobSet.jobs["0 30 1 * * * TestSharedJob1.godoit"], testStartTime))
+			assert.Equal(
+				t,
+				time.Date(2017, 1, 2, 6, 30, 0, 0, time.UTC),
+				other.nextRun("0 30 1 * * * TestSharedJob1.godoit", other.jobs["0 30 1 * * * TestSharedJob1.godoit"], testStartTime).UTC())
+
+			other.Stop()
+			assert.Equal(t, 1, scheduler.Len())
+		})
+	})
+}
 
 func TestScanRemoveJob(t *testing.T) {
 	withJobSet(func(jobSet *JobSet) {
 		createJob(jobSet, "* * * * * * TestScanRemoveJob.godoit")
 		assertRescanUpdates(t, jobSet, true)
 		assertJobCount(t, jobSet, 1)
-		time.Sleep(time.Second * 2)
+		advance(jobSet, time.Second * 2)
 
 		removeJob(t, jobSet, "* * * * * * TestScanRemoveJob.godoit")
 		assertRescanUpdates(t, jobSet, true)
 		assertJobCount(t, jobSet, 0)
-		time.Sleep(time.Second * 4)
-		assertExecutions(t, "TestScanRemoveJob", 1)
+		advance(jobSet, time.Second * 4)
+		assertExecutions(t, jobSet, "TestScanRemoveJob", 2)
 	})
 }
 
@@ -170,17 +318,17 @@ func TestScanSwapJobs(t *testing.T) {
 		assertRescanUpdates(t, jobSet, true)
 		jobSet.printJobs()
 		assertJobCount(t, jobSet, 2)
-		time.Sleep(time.Second * 2)
+		advance(jobSet, time.Second * 2)
 
 		createJob(jobSet, "* * * * * * TestScanSwapJobs_Other.godoit")
 		removeJob(t, jobSet, "* * * * * * TestScanSwapJobs.godoit")
 		assertRescanUpdates(t, jobSet, true)
 		jobSet.printJobs()
 		assertJobCount(t, jobSet, 2)
-		time.Sleep(time.Second * 5)
-		assertExecutions(t, "TestScanSwapJobs", 1)
-		assertExecutions(t, "TestScanSwapJobsX", 5)
-		assertExecutions(t, "TestScanSwapJobs_Other", 3)
+		advance(jobSet, time.Second * 5)
+		assertExecutions(t, jobSet, "TestScanSwapJobs", 2)
+		assertExecutions(t, jobSet, "TestScanSwapJobsX", 7)
+		assertExecutions(t, jobSet, "TestScanSwapJobs_Other", 5)
 	})
 }
 
@@ -196,18 +344,373 @@ func TestStatusScript(t *testing.T) {
 			"test_set": jobSet1,
 		}
 
-		statusFunc := StatusReporterFromScript("./test_status.sh", []string{"PATH"}, os.Stdout)
-		statusFunc(jobSetsMap)
+		statusReporter, _ := StatusReporterFromScript("./test_status.sh", os.Stdout)
+		statusReporter.Report(Status(jobSetsMap, []string{"PATH"}))
+	})
+}
+
+func TestStatusScriptMustBeDefined(t *testing.T) {
+	_, err := StatusReporterFromScript("", os.Stdout)
+	assert.Equal(t, "Status script is not defined", err.Error())
+}
+
+func TestStatusListsCronSpecs(t *testing.T) {
+	withJobSet(func(jobSet *JobSet) {
+		createJob(jobSet, "report.godoit", "#:godoit cronspec 0 0 6 * * 1-5", "#:godoit cronspec 0 0 10 * * 0,6 America/New_York")
+		jobSet.Scan()
+		info := Status(map[string]*JobSet{"test_set": jobSet}, []string{})
+		assert.Equal(t, []string{"0 0 6 * * 1-5", "0 0 10 * * 0,6 America/New_York"}, info.JobInfo[0].Jobs[0].Specs)
+		// Sunday 1 January 2017 at 10:00 in New York
+		assert.Equal(t, "20170101T15:00:00Z", info.JobInfo[0].Jobs[0].NextRun)
+	})
+}
+
+func TestMaxRunsMakesJobInactive(t *testing.T) {
+	withJobSet(func(jobSet *JobSet) {
+		stateFile := path.Join(jobSet.directory, "godoit.state")
+		jobSet.useRunState(loadRunState(stateFile))
+		createJob(jobSet, "0 1 * * * * TestMaxRunsMakesJobInactive.godoit", "#:godoit maxruns 2")
+		jobSet.Scan()
+		job := jobSet.jobs["0 1 * * * * TestMaxRunsMakesJobInactive.godoit"]
+
+		// Runs skipped in a blackout are not counted
+		window, _ := ParseBlackoutWindow("0 0 10 * * * 1h")
+		jobSet.SetBlackouts([]BlackoutWindow{window})
+		jobSet.runJob(job, "")
+		assert.Equal(t, 0, jobSet.runCount("0 1 * * * * TestMaxRunsMakesJobInactive.godoit", job))
+		jobSet.SetBlackouts(nil)
+
+		jobSet.runJob(job, "")
+		// The count is kept when godoit restarts
+		assert.Equal(t, 1, loadRunState(stateFile).count(job))
+		for i := 0; i < 2; i++ {
+			jobSet.runJob(job, "")
+		}
+		assertExecutions(t, jobSet, "TestMaxRunsMakesJobInactive", 2)
+		assert.Equal(t, 1, jobSet.scheduler.Len())
+
+		jobSet.Scan()
+		assert.Equal(t, 0, jobSet.scheduler.Len())
+		info := Status(map[string]*JobSet{"test_set": jobSet}, []string{})
+		assert.Equal(t, true, info.JobInfo[0].Jobs[0].Enabled)
+		assert.Equal(t, "ran maxruns 2 times", info.JobInfo[0].Jobs[0].Inactive)
+		assert.Equal(t, 2, info.JobInfo[0].Jobs[0].Runs)
+		assert.Equal(t, "", info.JobInfo[0].Jobs[0].NextRun)
+
+		restarted := NewJobSet(executor, jobSet.directory, JobDefaults{}, jobSet.clock)
+		restarted.useRunState(loadRunState(stateFile))
+		restarted.Scan()
+		defer restarted.Stop()
+		assert.Equal(t, 0, len(restarted.scheduled))
+
+		// A new version of the job starts counting again
+		createJob(jobSet, "0 1 * * * * TestMaxRunsMakesJobInactive.godoit", "#:godoit maxruns 2", "echo version 2")
+		restarted.Scan()
+		assert.Equal(t, 1, len(restarted.scheduled))
+	})
+}
+
+func TestEditedMaxRunsJobRunsAgain(t *testing.T) {
+	withJobSet(func(jobSet *JobSet) {
+		createJob(jobSet, "0 1 * * * * TestEditedMaxRunsJobRunsAgain.godoit", "#:godoit maxruns 2")
+		jobSet.Scan()
+		advance(jobSet, 2 * time.Hour)
+		assertExecutions(t, jobSet, "TestEditedMaxRunsJobRunsAgain", 2)
+
+		// A comment starts a new version of the job, which counts its own runs
+		createJob(jobSet, "0 1 * * * * TestEditedMaxRunsJobRunsAgain.godoit", "#:godoit maxruns 2", "# a comment")
+		jobSet.Scan()
+		job := jobSet.jobs["0 1 * * * * TestEditedMaxRunsJobRunsAgain.godoit"]
+		assert.Equal(t, job.Hash, jobSet.scheduled["0 1 * * * * TestEditedMaxRunsJobRunsAgain.godoit"].Hash)
+		advance(jobSet, time.Hour)
+		assertExecutions(t, jobSet, "TestEditedMaxRunsJobRunsAgain", 3)
+		assert.Equal(t, 1, jobSet.runCount("0 1 * * * * TestEditedMaxRunsJobRunsAgain.godoit", job))
+	})
+}
+
+func TestExpiredJobIsNotScheduled(t *testing.T) {
+	withJobSet(func(jobSet *JobSet) {
+		createJob(jobSet, "0 1 * * * * TestExpiredJobIsNotScheduled.godoit", "#:godoit until 2016-12-31")
+		jobSet.Scan()
+		assert.Equal(t, 0, len(jobSet.scheduled))
+		info := Status(map[string]*JobSet{"test_set": jobSet}, []string{})
+		assert.Equal(t, "until 2016-12-31 23:59:59 UTC has passed", info.JobInfo[0].Jobs[0].Inactive)
+	})
+}
+
+func TestStartupJobRunsWhenFound(t *testing.T) {
+	withJobSet(func(jobSet *JobSet) {
+		createJob(jobSet, "TestStartupJobRunsWhenFound.godoit", "#:godoit cronspec @startup")
+		jobSet.Scan()
+		assertExecutions(t, jobSet, "TestStartupJobRunsWhenFound", 1)
+		jobSet.Scan()
+		assertExecutions(t, jobSet, "TestStartupJobRunsWhenFound", 1)
+
+		// A job file which is removed and added again is found again
+		removeJob(t, jobSet, "TestStartupJobRunsWhenFound.godoit")
+		jobSet.Scan()
+		createJob(jobSet, "TestStartupJobRunsWhenFound.godoit", "#:godoit cronspec @startup")
+		jobSet.Scan()
+		assertExecutions(t, jobSet, "TestStartupJobRunsWhenFound", 2)
+	})
+}
+
+func TestOnceJobIsRemembered(t *testing.T) {
+	withJobSet(func(jobSet *JobSet) {
+		stateFile := path.Join(jobSet.directory, "godoit.state")
+		jobSet.useRunState(loadRunState(stateFile))
+		createJob(jobSet, "TestOnceJobIsRemembered.godoit", "#:godoit cronspec @once")
+		jobSet.Scan()
+		assertExecutions(t, jobSet, "TestOnceJobIsRemembered", 1)
+
+		// Restarting does not run the job again
+		restarted := NewJobSet(executor, jobSet.directory, JobDefaults{}, jobSet.clock)
+		restarted.useRunState(loadRunState(stateFile))
+		restarted.Scan()
+		assertExecutions(t, restarted, "TestOnceJobIsRemembered", 1)
+
+		// A new version of the job runs once
+		createJob(jobSet, "TestOnceJobIsRemembered.godoit", "#:godoit cronspec @once", "echo version 2")
+		restarted.Scan()
+		restarted.Scan()
+		assertExecutions(t, restarted, "TestOnceJobIsRemembered", 2)
+		restarted.Stop()
+	})
+}
+
+func TestRunOnceJobsWaitForBlackout(t *testing.T) {
+	withJobSet(func(jobSet *JobSet) {
+		stateFile := path.Join(jobSet.directory, "godoit.state")
+		jobSet.useRunState(loadRunState(stateFile))
+		window, _ := ParseBlackoutWindow("0 30 9 * * * 1h")
+		jobSet.SetBlackouts([]BlackoutWindow{window})
+		createJob(jobSet, "TestRunOnceJobsWaitForBlackoutOnce.godoit", "#:godoit cronspec @once")
+		createJob(jobSet, "TestRunOnceJobsWaitForBlackoutStartup.godoit", "#:godoit cronspec @startup")
+		jobSet.Scan()
+		// The scheduler waits with the two runs
+		clock := jobSet.clock.(*FakeClock)
+		waitFor(t, func() bool { return clock.Timers() == 3 })
+
+		// The @once run is not remembered until it starts, and is not started twice
+		jobSet.Scan()
+		assert.False(t, loadRunState(stateFile).hasRun(jobSet.jobs["TestRunOnceJobsWaitForBlackoutOnce.godoit"]))
+		assert.Equal(t, 3, clock.Timers())
+
+		advance(jobSet, 30 * time.Minute)
+		assertExecutions(t, jobSet, "TestRunOnceJobsWaitForBlackoutOnce", 1)
+		assertExecutions(t, jobSet, "TestRunOnceJobsWaitForBlackoutStartup", 1)
+		assert.True(t, loadRunState(stateFile).hasRun(jobSet.jobs["TestRunOnceJobsWaitForBlackoutOnce.godoit"]))
+		jobSet.Scan()
+		assertExecutions(t, jobSet, "TestRunOnceJobsWaitForBlackoutOnce", 1)
+	})
+}
+
+func TestFileTriggerRunsJob(t *testing.T) {
+	withJobSet(func(jobSet *JobSet) {
+		// The directory does not exist yet, so the files are polled for
+		createJob(jobSet, "TestFileTriggerRunsJob.godoit", "#:godoit trigger file in/*.csv debounce 10ms stable 50ms")
+		jobSet.Scan()
+		assert.Equal(t, 1, len(jobSet.triggers))
+
+		// Only files which appear or change trigger the job
+		os.Mkdir(path.Join(jobSet.directory, "in"), 0755)
+		createJob(jobSet, "in/new.csv", "a")
+		createJob(jobSet, "in/new.txt", "a")
+		advance(jobSet, triggerPollInterval + triggerCheckInterval)
+		assertExecutions(t, jobSet, "TestFileTriggerRunsJob", 1)
+		advance(jobSet, triggerPollInterval + triggerCheckInterval)
+		assertExecutions(t, jobSet, "TestFileTriggerRunsJob", 1)
+		createJob(jobSet, "in/new.csv", "a", "b")
+		advance(jobSet, triggerPollInterval + triggerCheckInterval)
+		assertExecutions(t, jobSet, "TestFileTriggerRunsJob", 2)
+
+		// A file which changes during a blackout runs the job when it ends
+		window, _ := ParseBlackoutWindow("0 0 10 * * * 1m")
+		jobSet.SetBlackouts([]BlackoutWindow{window})
+		clock := jobSet.clock.(*FakeClock)
+		timers := clock.Timers()
+		createJob(jobSet, "in/blackout.csv", "a")
+		advance(jobSet, triggerPollInterval + triggerCheckInterval)
+		waitFor(t, func() bool { return clock.Timers() == timers + 1 })
+		assertNoNewExecutions(t, "TestFileTriggerRunsJob", 2)
+		advance(jobSet, time.Minute)
+		assertExecutions(t, jobSet, "TestFileTriggerRunsJob", 3)
+
+		removeJob(t, jobSet, "TestFileTriggerRunsJob.godoit")
+		jobSet.Scan()
+		assert.Equal(t, 0, len(jobSet.triggers))
+	})
+}
+
+func TestFileTriggersRunTheirJobs(t *testing.T) {
+	withJobSet(func(jobSet *JobSet) {
+		names := []string{"TestFileTriggersRunTheirJobsA", "TestFileTriggersRunTheirJobsB", "TestFileTriggersRunTheirJobsC"}
+		for _, name := range names {
+			createJob(jobSet, name + ".godoit", "#:godoit trigger file " + name + "/*.csv")
+		}
+		jobSet.Scan()
+		assert.Equal(t, 3, len(jobSet.triggers))
+
+		for i, name := range names {
+			os.Mkdir(path.Join(jobSet.directory, name), 0755)
+			createJob(jobSet, name + "/in.csv", "a")
+			advance(jobSet, triggerPollInterval + defaultTriggerDebounce)
+			for j, other := range names {
+				if j <= i {
+					assertExecutions(t, jobSet, other, 1)
+				} else {
+					assertNoExecutions(t, jobSet, other)
+				}
+			}
+		}
+	})
+}
+
+func TestFileTriggerWaitsForStableFile(t *testing.T) {
+	withDir(func(dir string) {
+		createTestJob(dir, "existing.csv", "a")
+		triggered := []string{}
+		watcher := newTriggerWatcher(path.Join(dir, "*.csv"), FileTrigger{"*.csv", 100 * time.Millisecond, 300 * time.Millisecond}, RealClock, func(path string) {
+			triggered = append(triggered, path)
+		})
+
+		// The file is used once notifications stop for the debounce and it
+		// has not changed for stable
+		upload := path.Join(dir, "upload.csv")
+		createTestJob(dir, "upload.csv", "partial")
+		watcher.notify(upload, testStartTime)
+		watcher.check(testStartTime.Add(200 * time.Millisecond))
+		createTestJob(dir, "upload.csv", "partial", "partial")
+		watcher.check(testStartTime.Add(400 * time.Millisecond))
+		watcher.notify(upload, testStartTime.Add(500 * time.Millisecond))
+		watcher.check(testStartTime.Add(600 * time.Millisecond))
+		assert.Equal(t, []string{}, triggered)
+		watcher.check(testStartTime.Add(700 * time.Millisecond))
+		assert.Equal(t, []string{upload}, triggered)
+
+		// Each version of a file is used once, files which existed when the
+		// watcher started only once they change
+		watcher.notify(upload, testStartTime.Add(time.Second))
+		watcher.notify(path.Join(dir, "existing.csv"), testStartTime.Add(time.Second))
+		watcher.check(testStartTime.Add(2 * time.Second))
+		assert.Equal(t, []string{upload}, triggered)
+	})
+}
+
+func TestFileTriggerPollsWithoutNotifications(t *testing.T) {
+	withDir(func(dir string) {
+		clock := NewFakeClock(testStartTime)
+		triggered := make(chan string, 10)
+		// The directory cannot be watched until it exists, or with a wildcard
+		for _, pattern := range []string{path.Join(dir, "in", "*.csv"), path.Join(dir, "*", "*.txt")} {
+			watcher := startTriggerWatcher(pattern, FileTrigger{"", 0, 0}, clock, func(path string) {
+				triggered <- path
+			})
+			defer watcher.Stop()
+		}
+
+		os.Mkdir(path.Join(dir, "in"), 0755)
+		ioutil.WriteFile(path.Join(dir, "in", "a.csv"), []byte("a"), 0644)
+		ioutil.WriteFile(path.Join(dir, "in", "b.txt"), []byte("b"), 0644)
+		clock.Advance(triggerPollInterval - triggerCheckInterval)
+		assert.Equal(t, 0, len(triggered))
+		clock.Advance(2 * triggerCheckInterval)
+		assert.Equal(t, 2, len(triggered))
+	})
+}
+
+func TestRunJobRecordsResult(t *testing.T) {
+	withJobSet(func(jobSet *JobSet) {
+		createJob(jobSet, "0 1 * * * * TestRunJobRecordsResult.godoit")
+		jobSet.Scan()
+		_, ok := jobSet.lastResult("0 1 * * * * TestRunJobRecordsResult.godoit")
+		assert.False(t, ok)
+		assert.NotContains(t, string(ToJson(map[string]*JobSet{"test_set": jobSet}, []string{})), "lastRun")
+
+		jobSet.runJob(jobSet.jobs["0 1 * * * * TestRunJobRecordsResult.godoit"], "")
+		_, ok = jobSet.lastResult("0 1 * * * * TestRunJobRecordsResult.godoit")
+		assert.True(t, ok)
+		assert.Contains(t, string(ToJson(map[string]*JobSet{"test_set": jobSet}, []string{})), "lastRun")
+	})
+}
+
+func TestJitterDelaysRun(t *testing.T) {
+	withJobSet(func(jobSet *JobSet) {
+		createJob(jobSet, "0 1 * * * * TestJitterDelaysRun.godoit", "#:godoit jitter 300ms")
+		jobSet.Scan()
+		job := jobSet.jobs["0 1 * * * * TestJitterDelaysRun.godoit"]
+
+		// The delay for the next run is known in advance
+		nextRun := jobSet.nextRun("0 1 * * * * TestJitterDelaysRun.godoit", job, testStartTime)
+		delay := nextRun.Sub(time.Date(2017, 1, 1, 10, 1, 0, 0, time.UTC))
+		assert.True(t, delay >= 0 && delay < 300 * time.Millisecond)
+
+		// The run waits for the delay
+		clock := jobSet.clock.(*FakeClock)
+		done := make(chan bool)
+		go func() {
+			jobSet.runJob(job, "")
+			done <- true
+		}()
+		waitFor(t, func() bool { return clock.Timers() == 2 })
+		advance(jobSet, delay - time.Nanosecond)
+		_, ok := jobSet.lastResult("0 1 * * * * TestJitterDelaysRun.godoit")
+		assert.False(t, ok)
+		advance(jobSet, time.Nanosecond)
+		<-done
+		result, _ := jobSet.lastResult("0 1 * * * * TestJitterDelaysRun.godoit")
+		assert.Equal(t, delay, result.Jitter)
+	})
+}
+
+func TestParseBlackoutWindow(t *testing.T) {
+	window, err := ParseBlackoutWindow("0 0 2 * * SUN Europe/London 3h")
+	assert.Nil(t, err)
+	assert.Equal(t, "0 0 2 * * SUN Europe/London 3h0m0s", window.String())
+	assert.False(t, window.Contains(time.Date(2017, 1, 8, 1, 59, 59, 0, time.UTC)))
+	assert.True(t, window.Contains(time.Date(2017, 1, 8, 2, 0, 0, 0, time.UTC)))
+	assert.True(t, window.Contains(time.Date(2017, 1, 8, 4, 59, 59, 0, time.UTC)))
+	assert.False(t, window.Contains(time.Date(2017, 1, 8, 5, 0, 0, 0, time.UTC)))
+
+	for _, value := range []string{"0 0 2 * * SUN", "0 0 2 * * SUN Mars/Base 3h", "0 0 2 * * SUN -1h", "3h"} {
+		_, err := ParseBlackoutWindow(value)
+		assert.Equal(t, "Invalid blackout: '" + value + "'", err.Error())
+	}
+}
+
+func TestBlackoutSkipsRuns(t *testing.T) {
+	withJobSet(func(jobSet *JobSet) {
+		createJob(jobSet, "0 0 * * * * TestBlackoutSkipsRuns.godoit")
+		jobSet.Scan()
+		job := jobSet.jobs["0 0 * * * * TestBlackoutSkipsRuns.godoit"]
+		assert.Equal(t, time.Date(2017, 1, 1, 11, 0, 0, 0, time.UTC), jobSet.scheduler.Next(job.Filepath))
+
+		// Windows which overlap are skipped together
+		first, _ := ParseBlackoutWindow("0 30 10 * * * 1h")
+		second, _ := ParseBlackoutWindow("0 0 11 * * * 2h")
+		jobSet.SetBlackouts([]BlackoutWindow{first, second})
+		assert.Equal(t, time.Date(2017, 1, 1, 13, 0, 0, 0, time.UTC), jobSet.scheduler.Next(job.Filepath))
+		next, note := jobSet.NextRun(job, testStartTime)
+		assert.Equal(t, time.Date(2017, 1, 1, 13, 0, 0, 0, time.UTC), next)
+		assert.Equal(t, "skipped 2017-01-01 11:00:00 UTC in blackout 0 30 10 * * * 1h0m0s", note)
+
+		jobSet.SetBlackouts(nil)
+		assert.Equal(t, time.Date(2017, 1, 1, 11, 0, 0, 0, time.UTC), jobSet.scheduler.Next(job.Filepath))
 	})
 }
 
+// testStartTime is the time of the fake clock when a test starts
+var testStartTime = time.Date(2017, 1, 1, 10, 0, 0, 0, time.UTC)
 
 type withJobSetFunc func(jobSet *JobSet)
 
 func withJobSet(aFunc withJobSetFunc) {
 	dir,_ := ioutil.TempDir("", "")
 	defer os.RemoveAll(dir)
-	jobSet := NewJobSet(executor, dir)
+	lock.Lock()
+	executions = make(map [string]int)
+	lock.Unlock()
+	jobSet := NewJobSet(executor, dir, JobDefaults{}, NewFakeClock(testStartTime))
 	defer jobSet.Stop()
 	println(dir)
 	aFunc(jobSet)
@@ -237,20 +740,40 @@ func assertJobCount(t *testing.T, jobSet *JobSet, expectedJobs int) {
 	assert.Equal(t,expectedJobs, len(jobSet.jobs))
 }
 
-func assertExecutions(t *testing.T, name string, minCount int) {
+// assertExecutions waits for the runs the job set started to finish and checks
+// the number of executions of the job
+func assertExecutions(t *testing.T, jobSet *JobSet, name string, count int) {
+	jobSet.running.Wait()
 	lock.RLock()
 	defer  lock.RUnlock()
 
 	if actualCount,ok := executions[name]; ok {
-		assert.True(
-			t,actualCount >= minCount,
-			"Number of executions should be at least %n but was %n", minCount, actualCount)
+		assert.Equal(t, count, actualCount, "Number of executions of %s", name)
 	} else {
 		assert.Fail(t,"No executions found for job %s", name)
 	}
 }
 
-func assertNoExecutions(t *testing.T, name string) {
+// executionCount returns the number of executions of the job so far
+func executionCount(name string) int {
+	lock.RLock()
+	defer lock.RUnlock()
+	return executions[name]
+}
+
+// advance moves the fake clock of the job set forward
+func advance(jobSet *JobSet, d time.Duration) {
+	jobSet.clock.(*FakeClock).Advance(d)
+}
+
+// assertNoNewExecutions checks a job has not run since it ran count times,
+// without waiting for the runs the job set started
+func assertNoNewExecutions(t *testing.T, name string, count int) {
+	assert.Equal(t, count, executionCount(name), "Number of executions of %s", name)
+}
+
+func assertNoExecutions(t *testing.T, jobSet *JobSet, name string) {
+	jobSet.running.Wait()
 	lock.RLock()
 	defer  lock.RUnlock()
 
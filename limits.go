@@ -0,0 +1,221 @@
+package godoit
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ResourceLimits are applied to the job process before it starts. Rlimits are
+// keyed by the prlimit resource name and limits which are not set are inherited
+// from godoit. Memory in bytes and CPU as a percentage of one CPU are applied
+// using a cgroup.
+type ResourceLimits struct {
+	Rlimits map[string]uint64 `json:"rlimits"`
+	Nice int `json:"nice"`
+	IOClass string `json:"ioClass"`
+	IOLevel int `json:"ioLevel"`
+	Memory uint64 `json:"memory"`
+	CPU int `json:"cpu"`
+}
+
+// unlimited is the value of an rlimit which has no limit
+const unlimited = ^uint64(0)
+
+var rlimitParams = map[string]string{
+	"rlimit-as": "as",
+	"rlimit-nofile": "nofile",
+	"rlimit-cpu": "cpu",
+	"rlimit-core": "core",
+}
+
+// defaultIOLevel is the level of the realtime and best-effort I/O classes when
+// it is not given, the level the kernel gives a process with nice value 0
+const defaultIOLevel = 4
+
+var ioClasses = map[string]string{
+	"realtime": "1",
+	"best-effort": "2",
+	"idle": "3",
+}
+
+// lookLimitCommand finds the util-linux commands which apply the limits
+var lookLimitCommand = exec.LookPath
+
+var sizeSuffixes = map[string]uint64{
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+}
+
+func isLimitParameter(param string) bool {
+	_, ok := rlimitParams[param]
+	return ok || param == "nice" || param == "ionice" || param == "memory" || param == "cpu"
+}
+
+func applyLimitParameter(job *Job, param, value string) {
+	if resource, ok := rlimitParams[param]; ok {
+		var limit uint64
+		var err error
+		if resource == "cpu" {
+			limit, err = parseSeconds(value)
+		} else if resource == "nofile" {
+			limit, err = parseCount(value)
+		} else {
+			limit, err = parseSize(value)
+		}
+		if err != nil {
+			job.Errors = append(job.Errors, fmt.Sprintf("Invalid %s: '%s'", param, value))
+			return
+		}
+		if job.Limits.Rlimits == nil {
+			job.Limits.Rlimits = make(map[string]uint64)
+		}
+		job.Limits.Rlimits[resource] = limit
+		checkLimitCommand(job, param, "prlimit")
+	} else if param == "nice" {
+		if nice, err := strconv.Atoi(value); err == nil && nice >= -20 && nice <= 19 {
+			job.Limits.Nice = nice
+			checkLimitCommand(job, param, "nice")
+		} else {
+			job.Errors = append(job.Errors, fmt.Sprintf("Invalid nice: '%s'", value))
+		}
+	} else if param == "memory" {
+		if memory, err := parseSize(value); err == nil && memory > 0 {
+			job.Limits.Memory = memory
+		} else {
+			job.Errors = append(job.Errors, fmt.Sprintf("Invalid memory: '%s'", value))
+		}
+	} else if param == "cpu" {
+		if cpu, err := strconv.Atoi(strings.TrimSuffix(value, "%")); err == nil && cpu > 0 {
+			job.Limits.CPU = cpu
+		} else {
+			job.Errors = append(job.Errors, fmt.Sprintf("Invalid cpu: '%s'", value))
+		}
+	} else if param == "ionice" {
+		parts := strings.Fields(value)
+		level := 0
+		valid := len(parts) == 1 || len(parts) == 2
+		if valid {
+			_, valid = ioClasses[parts[0]]
+		}
+		if valid && parts[0] != "idle" {
+			level = defaultIOLevel
+		}
+		if valid && len(parts) == 2 {
+			var err error
+			level, err = strconv.Atoi(parts[1])
+			valid = err == nil && level >= 0 && level <= 7 && parts[0] != "idle"
+		}
+		if valid {
+			job.Limits.IOClass = parts[0]
+			job.Limits.IOLevel = level
+			checkLimitCommand(job, param, "ionice")
+		} else {
+			job.Errors = append(job.Errors, fmt.Sprintf("Invalid ionice: '%s'", value))
+		}
+	}
+}
+
+// checkLimitCommand adds an error to the job if the command which applies the
+// limit is not installed
+func checkLimitCommand(job *Job, param, command string) {
+	if _, err := lookLimitCommand(command); err != nil {
+		job.Errors = append(job.Errors, fmt.Sprintf("Unable to apply %s, %s is not installed", param, command))
+	}
+}
+
+// limitCommand wraps the command so that it is started with the limits applied,
+// using nice, ionice and prlimit from util-linux. A nice value of 0 is not set,
+// so the job runs with the nice value of godoit.
+func limitCommand(limits ResourceLimits, name string, args ...string) (string, []string) {
+	command := []string{}
+	if limits.Nice != 0 {
+		command = append(command, "nice", "-n", strconv.Itoa(niceIncrement(limits.Nice)))
+	}
+	if limits.IOClass != "" {
+		command = append(command, "ionice", "-c", ioClasses[limits.IOClass])
+		if limits.IOClass != "idle" {
+			command = append(command, "-n", strconv.Itoa(limits.IOLevel))
+		}
+	}
+	if len(limits.Rlimits) > 0 {
+		command = append(command, "prlimit")
+		resources := make([]string, 0, len(limits.Rlimits))
+		for resource := range limits.Rlimits {
+			resources = append(resources, resource)
+		}
+		sort.Strings(resources)
+		for _, resource := range resources {
+			command = append(command, fmt.Sprintf("--%s=%s", resource, limitString(limits.Rlimits[resource])))
+		}
+		command = append(command, "--")
+	}
+	if len(command) == 0 {
+		return name, args
+	}
+	return command[0], append(append(command[1:], name), args...)
+}
+
+// niceIncrement returns the adjustment nice makes to the nice value of godoit
+// so the job runs with the nice value given
+func niceIncrement(nice int) int {
+	// The system call returns 20 minus the nice value so it is never negative
+	priority, err := syscall.Getpriority(syscall.PRIO_PROCESS, 0)
+	if err != nil {
+		return nice
+	}
+	return nice - (20 - priority)
+}
+
+func limitString(limit uint64) string {
+	if limit == unlimited {
+		return "unlimited"
+	}
+	return strconv.FormatUint(limit, 10)
+}
+
+// parseSize parses a size in bytes with an optional K, M, G or T suffix
+func parseSize(value string) (uint64, error) {
+	if value == "unlimited" {
+		return unlimited, nil
+	} else if value == "" {
+		return 0, fmt.Errorf("missing size")
+	}
+	multiplier := uint64(1)
+	if suffix, ok := sizeSuffixes[strings.ToUpper(value[len(value)-1:])]; ok && len(value) > 1 {
+		multiplier = suffix
+		value = value[:len(value)-1]
+	}
+	size, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, err
+	} else if size > unlimited / multiplier {
+		return 0, fmt.Errorf("size %s is too large", value)
+	}
+	return size * multiplier, nil
+}
+
+// parseSeconds parses a number of seconds or a duration such as 10m
+func parseSeconds(value string) (uint64, error) {
+	if seconds, err := parseCount(value); err == nil {
+		return seconds, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < time.Second {
+		return 0, fmt.Errorf("invalid number of seconds %s", value)
+	}
+	return uint64(d.Seconds()), nil
+}
+
+func parseCount(value string) (uint64, error) {
+	if value == "unlimited" {
+		return unlimited, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
@@ -0,0 +1,120 @@
+package godoit
+
+import (
+	"log"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RunQueue limits the number of jobs running at once, in total and in each
+// directory. Runs waiting for a slot start in order of priority and then the
+// time they were scheduled. A limit of 0 is unlimited.
+type RunQueue struct {
+	clock Clock
+	maxJobs int
+	maxPerDirectory int
+	lock sync.Mutex
+	running int
+	runningIn map[string]int
+	waiting []*queuedRun
+	sequence uint64
+}
+
+type queuedRun struct {
+	directory string
+	priority int
+	scheduled time.Time
+	sequence uint64
+	ready chan bool
+}
+
+func NewRunQueue(maxJobs, maxPerDirectory int, clock Clock) *RunQueue {
+	return &RunQueue{
+		clock: clock,
+		maxJobs: maxJobs,
+		maxPerDirectory: maxPerDirectory,
+		runningIn: make(map[string]int)}
+}
+
+// Executor wraps the executor so that runs wait in the queue for a slot
+func (queue *RunQueue) Executor(executor Executor) Executor {
+	return ExecutorFunc(func(job Job) RunResult {
+		scheduled := queue.clock.Now()
+		directory := filepath.Dir(job.Filepath)
+		queue.acquire(directory, job.Priority, scheduled)
+		defer queue.release(directory)
+		wait := queue.clock.Now().Sub(scheduled)
+		if wait > time.Second {
+			log.Printf("Job %s (%s) waited %s to start", job.Name, directory, wait)
+		}
+
+		result := executor.Execute(job)
+		result.QueueWait = wait
+		return result
+	})
+}
+
+// Waiting returns the number of runs waiting for a slot
+func (queue *RunQueue) Waiting() int {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	return len(queue.waiting)
+}
+
+func (queue *RunQueue) acquire(directory string, priority int, scheduled time.Time) {
+	queue.lock.Lock()
+	queue.sequence++
+	run := &queuedRun{directory, priority, scheduled, queue.sequence, make(chan bool, 1)}
+	queue.waiting = append(queue.waiting, run)
+	sort.Slice(queue.waiting, func(i, j int) bool {
+		return queue.waiting[i].before(queue.waiting[j])
+	})
+	queue.dispatch()
+	queue.lock.Unlock()
+
+	<-run.ready
+}
+
+func (queue *RunQueue) release(directory string) {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	queue.running--
+	queue.runningIn[directory]--
+	if queue.runningIn[directory] == 0 {
+		delete(queue.runningIn, directory)
+	}
+	queue.dispatch()
+}
+
+// dispatch starts waiting runs while there are free slots, a run which is held
+// back by its directory limit does not hold back runs in other directories.
+func (queue *RunQueue) dispatch() {
+	waiting := queue.waiting[:0]
+	for _, run := range queue.waiting {
+		if queue.hasSlot(run.directory) {
+			queue.running++
+			queue.runningIn[run.directory]++
+			run.ready <- true
+		} else {
+			waiting = append(waiting, run)
+		}
+	}
+	queue.waiting = waiting
+}
+
+func (queue *RunQueue) hasSlot(directory string) bool {
+	return (queue.maxJobs <= 0 || queue.running < queue.maxJobs) &&
+		(queue.maxPerDirectory <= 0 || queue.runningIn[directory] < queue.maxPerDirectory)
+}
+
+func (run *queuedRun) before(other *queuedRun) bool {
+	if run.priority != other.priority {
+		return run.priority > other.priority
+	}
+	if !run.scheduled.Equal(other.scheduled) {
+		return run.scheduled.Before(other.scheduled)
+	}
+	return run.sequence < other.sequence
+}
@@ -0,0 +1,106 @@
+package godoit
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"time"
+)
+
+func TestQueueRunsByPriorityThenScheduleOrder(t *testing.T) {
+	queue := NewRunQueue(1, 0, RealClock)
+	release := make(chan bool)
+	started := make(chan string, 4)
+	queuedExecutor := queue.Executor(ExecutorFunc(func(job Job) RunResult {
+		started <- job.Name
+		start := time.Now()
+		<-release
+		return RunResult{Start: start}
+	}))
+
+	var wg sync.WaitGroup
+	results := make(map[string]RunResult)
+	var resultsLock sync.Mutex
+	run := func(job Job) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := queuedExecutor.Execute(job)
+			resultsLock.Lock()
+			results[job.Name] = result
+			resultsLock.Unlock()
+		}()
+	}
+	run(Job{Name: "first", Filepath: "/a/first.godoit"})
+	assert.Equal(t, "first", <-started)
+	run(Job{Name: "low", Filepath: "/a/low.godoit"})
+	waitFor(t, func() bool { return queue.Waiting() == 1 })
+	run(Job{Name: "later", Filepath: "/b/later.godoit"})
+	waitFor(t, func() bool { return queue.Waiting() == 2 })
+	run(Job{Name: "high", Filepath: "/b/high.godoit", Priority: 10})
+	waitFor(t, func() bool { return queue.Waiting() == 3 })
+
+	time.Sleep(50 * time.Millisecond)
+	order := []string{}
+	for i := 0; i < 3; i++ {
+		release <- true
+		order = append(order, <-started)
+	}
+	release <- true
+	wg.Wait()
+
+	assert.Equal(t, []string{"high", "low", "later"}, order)
+	assert.True(t, results["first"].QueueWait < 50 * time.Millisecond)
+	assert.True(t, results["later"].QueueWait >= 50 * time.Millisecond)
+}
+
+func TestQueueDirectoryLimit(t *testing.T) {
+	queue := NewRunQueue(0, 1, RealClock)
+	release := make(chan bool)
+	started := make(chan string, 3)
+	queuedExecutor := queue.Executor(ExecutorFunc(func(job Job) RunResult {
+		started <- job.Name
+		<-release
+		return RunResult{Start: time.Now()}
+	}))
+
+	go queuedExecutor.Execute(Job{Name: "a1", Filepath: "/a/a1.godoit"})
+	assert.Equal(t, "a1", <-started)
+	go queuedExecutor.Execute(Job{Name: "a2", Filepath: "/a/a2.godoit"})
+	waitFor(t, func() bool { return queue.Waiting() == 1 })
+
+	// A job in another directory is not held back by the waiting job
+	go queuedExecutor.Execute(Job{Name: "b1", Filepath: "/b/b1.godoit"})
+	assert.Equal(t, "b1", <-started)
+	assert.Equal(t, 1, queue.Waiting())
+
+	release <- true
+	release <- true
+	assert.Equal(t, "a2", <-started)
+	release <- true
+}
+
+func TestQueueWaitUsesTheQueueClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	queue := NewRunQueue(1, 0, clock)
+	queuedExecutor := queue.Executor(SelectExecutor("unknown", ExecutorOptions{}))
+
+	result := queuedExecutor.Execute(Job{Name: "job", Filepath: "/a/job.godoit"})
+	assert.NotNil(t, result.Error)
+	assert.Equal(t, time.Duration(0), result.QueueWait)
+
+	result = queue.Executor(ExecutorFunc(func(job Job) RunResult {
+		return RunResult{}
+	})).Execute(Job{Name: "job", Filepath: "/a/job.godoit"})
+	assert.Equal(t, time.Duration(0), result.QueueWait)
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	for i := 0; i < 100; i++ {
+		if condition() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for condition")
+}
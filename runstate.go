@@ -0,0 +1,110 @@
+package godoit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// runState remembers the @once jobs which have run and how many times the jobs
+// with maxruns have run, keyed by the path and content hash of the job file, in
+// a JSON file so they are kept when godoit restarts
+type runState struct {
+	file string
+	runs map[string]time.Time
+	counts map[string]int
+	lock sync.Mutex
+}
+
+// runStateFile is the content of the state file
+type runStateFile struct {
+	Once map[string]time.Time `json:"once"`
+	MaxRuns map[string]int `json:"maxRuns"`
+}
+
+// loadRunState reads the state file, an empty file name keeps the state in
+// memory only
+func loadRunState(file string) *runState {
+	state := &runState{file: file, runs: make(map[string]time.Time), counts: make(map[string]int)}
+	if file == "" {
+		return state
+	}
+	data, err := ioutil.ReadFile(os.ExpandEnv(file))
+	content := runStateFile{}
+	if os.IsNotExist(err) {
+		return state
+	} else if err != nil {
+		log.Printf("ERROR: Unable to read state file %s, %s", file, err)
+	} else if err := json.Unmarshal(data, &content); err != nil {
+		log.Printf("ERROR: Invalid state file %s, %s", file, err)
+	}
+	if content.Once != nil {
+		state.runs = content.Once
+	}
+	if content.MaxRuns != nil {
+		state.counts = content.MaxRuns
+	}
+	return state
+}
+
+func onceKey(job Job) string {
+	return job.Filepath + " " + job.Hash
+}
+
+// hasRun returns whether this version of the job has run
+func (state *runState) hasRun(job Job) bool {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	_, ok := state.runs[onceKey(job)]
+	return ok
+}
+
+// record records that the job is run, returning false if it had already run
+func (state *runState) record(job Job, now time.Time) bool {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if _, ok := state.runs[onceKey(job)]; ok {
+		return false
+	}
+	state.runs[onceKey(job)] = now
+	state.save()
+	return true
+}
+
+// count returns the number of times this version of the job has run
+func (state *runState) count(job Job) int {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return state.counts[onceKey(job)]
+}
+
+// countRun counts a run of this version of the job, returning false if it has
+// already run maxruns times
+func (state *runState) countRun(job Job) bool {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if state.counts[onceKey(job)] >= job.MaxRuns {
+		return false
+	}
+	state.counts[onceKey(job)]++
+	state.save()
+	return true
+}
+
+// save writes the state file, the lock must be held
+func (state *runState) save() {
+	if state.file == "" {
+		return
+	}
+	// Write a new file and rename it so the state is never left partly written
+	file := os.ExpandEnv(state.file)
+	data, _ := json.MarshalIndent(runStateFile{state.runs, state.counts}, "", "  ")
+	if err := ioutil.WriteFile(file + ".tmp", data, 0644); err != nil {
+		log.Printf("ERROR: Unable to write state file %s, %s", state.file, err)
+	} else if err := os.Rename(file + ".tmp", file); err != nil {
+		log.Printf("ERROR: Unable to write state file %s, %s", state.file, err)
+	}
+}
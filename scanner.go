@@ -1,32 +1,92 @@
-package main
+package godoit
 import (
+	"fmt"
 	"log"
-	"path/filepath"
-	"os"
-	"path"
-	"io"
+	"sort"
+	"sync"
 )
 
-type GoDoItScanner struct {
-	executor JobExecutor
-	config *GoDoItConfig
+// ScannerOptions configures the directories a Scanner looks for jobs in and
+// how it runs them
+type ScannerOptions struct {
+	// Include are glob patterns of the directories to scan for jobs, `**`
+	// matches any number of directories
+	Include []string
+	// Exclude are glob patterns of the directories and job files not to scan,
+	// a pattern without a `/` matches the name of the directory or file
+	Exclude []string
+	// Recursive includes the directories below each included directory
+	Recursive bool
+	// MaxDepth limits how many levels below a directory `**` and Recursive
+	// look for directories, 0 is no limit
+	MaxDepth int
+	// Defaults apply to every job unless the job file sets the parameter
+	Defaults JobDefaults
+	// Executor runs the jobs
+	Executor Executor
+	// MaxConcurrentJobs and MaxConcurrentJobsPerDirectory limit the number of
+	// jobs running at once, 0 is unlimited
+	MaxConcurrentJobs int
+	MaxConcurrentJobsPerDirectory int
+	// Clock schedules the jobs, the RealClock if not set
+	Clock Clock
+	// StateFile records the @once jobs which have run and the runs of jobs
+	// with maxruns, they are only remembered while running if it is not set
+	StateFile string
+	// Blackouts are windows during which no jobs start
+	Blackouts []BlackoutWindow
+	// StatusExcluded includes the excluded directories and job files in the
+	// status
+	StatusExcluded bool
+}
+
+// Scanner finds the directories matching the include patterns and schedules
+// the jobs in each of them
+type Scanner struct {
+	executor Executor
+	options ScannerOptions
+	clock Clock
+	scheduler *jobScheduler
+	runState *runState
 	jobSets map[string]*JobSet
+	excluded []string
+	lock sync.Mutex
 }
 
-func NewScanner(config *GoDoItConfig, output io.Writer) *GoDoItScanner {
-	return &GoDoItScanner{
-		JobExecutorFromScript(config.JobExecutorScript, output),
-		config,
-		make(map[string]*JobSet)}
+// NewScanner creates a Scanner with the options, returning an error if they
+// have no Executor
+func NewScanner(options ScannerOptions) (*Scanner, error) {
+	if options.Executor == nil {
+		return nil, fmt.Errorf("Scanner has no executor")
+	}
+	clock := options.Clock
+	if clock == nil {
+		clock = RealClock
+	}
+	queue := NewRunQueue(options.MaxConcurrentJobs, options.MaxConcurrentJobsPerDirectory, clock)
+	scheduler := newJobScheduler(clock)
+	scheduler.Start()
+	return &Scanner{
+		executor: queue.Executor(options.Executor),
+		options: options,
+		clock: clock,
+		scheduler: scheduler,
+		runState: loadRunState(options.StateFile),
+		jobSets: make(map[string]*JobSet)}, nil
 }
 
-func (scanner *GoDoItScanner) Run() {
+// Run scans for changes and logs the jobs if any changed
+func (scanner *Scanner) Run() {
 	if scanner.Scan() {
 		scanner.PrintJobs()
 	}
 }
 
-func (scanner *GoDoItScanner) Scan() bool {
+// Scan looks for added and removed directories and changed jobs, returning
+// whether anything changed
+func (scanner *Scanner) Scan() bool {
+	scanner.lock.Lock()
+	defer scanner.lock.Unlock()
 	log.Println("Scanning for changes...")
 	foundDirectories := make(map [string]bool)
 
@@ -44,32 +104,24 @@ func (scanner *GoDoItScanner) Scan() bool {
 	return jobsChanged
 }
 
-func scanPatterns(scanner *GoDoItScanner, foundDirectories map[string]bool) bool {
-	updated := false
-	for _,element := range scanner.config.Include {
-		path := path.Clean(os.ExpandEnv(element))
-		log.Printf("  Scanning directories matching %s", path)
-		directories,err := filepath.Glob(path)
-		if err != nil {
-			log.Printf("  Failed to scan, %s", path)
-			continue
-		}
-		thisUpdated := ensureDirectory(scanner, directories, foundDirectories)
-		updated = updated || thisUpdated
-	}
-	return updated
+func scanPatterns(scanner *Scanner, foundDirectories map[string]bool) bool {
+	directories, excluded, reasons := scanner.options.discover()
+	logExcluded("directory", scanner.excluded, excluded, reasons)
+	scanner.excluded = excluded
+	return ensureDirectory(scanner, directories, foundDirectories)
 }
 
-func ensureDirectory(scanner *GoDoItScanner, directories []string, foundDirectories map[string]bool) bool {
+func ensureDirectory(scanner *Scanner, directories []string, foundDirectories map[string]bool) bool {
 	updated := false
 	for _,directory := range directories {
-		if info, err := os.Stat(directory); err != nil || ! info.IsDir() {
-			continue
-		}
 		foundDirectories[directory] = true
 		if _,ok := scanner.jobSets[directory]; ! ok {
 			log.Printf("  Adding directory, %s", directory)
-			jobSet := NewJobSet(scanner.executor, directory)
+			jobSet := NewJobSet(scanner.executor, directory, scanner.options.Defaults, scanner.clock)
+			jobSet.SetExclude(scanner.options.Exclude)
+			jobSet.SetBlackouts(scanner.options.Blackouts)
+			jobSet.useScheduler(scanner.scheduler)
+			jobSet.useRunState(scanner.runState)
 			scanner.jobSets[directory] = jobSet
 			jobSet.Scan()
 			updated = true
@@ -78,7 +130,7 @@ func ensureDirectory(scanner *GoDoItScanner, directories []string, foundDirector
 	return updated
 }
 
-func removeDirectories(scanner *GoDoItScanner, foundDirectories map[string]bool) bool {
+func removeDirectories(scanner *Scanner, foundDirectories map[string]bool) bool {
 	updated := false
 	for directory,jobSet := range scanner.jobSets {
 		if _, ok := foundDirectories[directory]; ! ok {
@@ -90,7 +142,7 @@ func removeDirectories(scanner *GoDoItScanner, foundDirectories map[string]bool)
 	return updated
 }
 
-func scanDirectory(scanner *GoDoItScanner) bool {
+func scanDirectory(scanner *Scanner) bool {
 	updated := false
 	for _,jobSet := range scanner.jobSets {
 		thisUpdated := jobSet.Scan()
@@ -99,16 +151,84 @@ func scanDirectory(scanner *GoDoItScanner) bool {
 	return updated
 }
 
-func (scanner *GoDoItScanner) PrintJobs() {
+// SetInclude changes the patterns of the directories to scan, directories
+// which no longer match are removed by the next scan
+func (scanner *Scanner) SetInclude(include []string) {
+	scanner.lock.Lock()
+	defer scanner.lock.Unlock()
+	scanner.options.Include = include
+}
+
+// SetExclude changes the patterns of the directories and job files not to
+// scan, which are removed by the next scan
+func (scanner *Scanner) SetExclude(exclude []string) {
+	scanner.lock.Lock()
+	defer scanner.lock.Unlock()
+	scanner.options.Exclude = exclude
+	for _,jobSet := range scanner.jobSets {
+		jobSet.SetExclude(exclude)
+	}
+}
+
+// SetBlackouts changes the windows during which no jobs start, rescheduling
+// the jobs to skip them
+func (scanner *Scanner) SetBlackouts(blackouts []BlackoutWindow) {
+	scanner.lock.Lock()
+	defer scanner.lock.Unlock()
+	scanner.options.Blackouts = blackouts
+	for _,jobSet := range scanner.jobSets {
+		jobSet.SetBlackouts(blackouts)
+	}
+}
+
+// JobSets returns the directories of jobs ordered by directory
+func (scanner *Scanner) JobSets() []*JobSet {
+	scanner.lock.Lock()
+	defer scanner.lock.Unlock()
+	directories := make([]string, 0, len(scanner.jobSets))
+	for directory := range scanner.jobSets {
+		directories = append(directories, directory)
+	}
+	sort.Strings(directories)
+	jobSets := make([]*JobSet, len(directories))
+	for i, directory := range directories {
+		jobSets[i] = scanner.jobSets[directory]
+	}
+	return jobSets
+}
+
+// Status returns the status of every job, including the values of the
+// environment variables given
+func (scanner *Scanner) Status(statusEnvironment []string) GodoitInfo {
+	scanner.lock.Lock()
+	defer scanner.lock.Unlock()
+	info := Status(scanner.jobSets, statusEnvironment)
+	if scanner.options.StatusExcluded {
+		info.Excluded = append([]string{}, scanner.excluded...)
+		for _,jobSet := range scanner.jobSets {
+			info.Excluded = append(info.Excluded, jobSet.Excluded()...)
+		}
+		sort.Strings(info.Excluded)
+	}
+	return info
+}
+
+func (scanner *Scanner) PrintJobs() {
+	scanner.lock.Lock()
+	defer scanner.lock.Unlock()
 	for _,jobSet := range scanner.jobSets {
 		jobSet.printJobs()
 	}}
 
-func (scanner *GoDoItScanner) Stop() {
+// Stop stops scheduling jobs, runs which have started are not stopped
+func (scanner *Scanner) Stop() {
+	scanner.lock.Lock()
+	defer scanner.lock.Unlock()
 	log.Println("Stopping jobs...")
 	for _,jobSet := range scanner.jobSets {
 		jobSet.Stop()
 	}
+	scanner.scheduler.Stop()
 	log.Println("  Stopping jobs...done")
 }
 
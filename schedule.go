@@ -0,0 +1,108 @@
+package godoit
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"github.com/robfig/cron"
+)
+
+// Cronspecs of jobs which run once rather than on a schedule
+const (
+	// StartupSpec runs the job when godoit starts or first finds the job
+	StartupSpec = "@startup"
+	// OnceSpec runs the job once ever for each version of the job file
+	OnceSpec = "@once"
+)
+
+// CronSpec is one of the schedules of a job, in its own timezone or the job's
+// timezone if it does not have one
+type CronSpec struct {
+	Spec string
+	Timezone *time.Location
+}
+
+// String returns the cronspec followed by its timezone if it has one
+func (spec CronSpec) String() string {
+	if spec.Timezone == nil {
+		return spec.Spec
+	}
+	return spec.Spec + " " + spec.Timezone.String()
+}
+
+// isRunOnceSpec returns whether the cronspec runs the job once rather than on
+// a schedule
+func isRunOnceSpec(spec string) bool {
+	return spec == StartupSpec || spec == OnceSpec
+}
+
+// parseCronSpec parses a cronspec which may be followed by a timezone
+func parseCronSpec(value string) (CronSpec, error) {
+	if isRunOnceSpec(value) {
+		return CronSpec{Spec: value}, nil
+	}
+	if _, err := cron.Parse(value); err == nil {
+		return CronSpec{Spec: value}, nil
+	}
+	fields := strings.Fields(value)
+	if len(fields) > 1 {
+		spec := strings.Join(fields[:len(fields)-1], " ")
+		location, err := time.LoadLocation(fields[len(fields)-1])
+		if _, specErr := cron.Parse(spec); err == nil && specErr == nil {
+			return CronSpec{spec, location}, nil
+		}
+	}
+	return CronSpec{}, fmt.Errorf("Invalid cronspec: '%s'", value)
+}
+
+// notedSchedule is a schedule which can explain why it runs at a time
+type notedSchedule interface {
+	next(t time.Time) (time.Time, string)
+}
+
+// nextWithNote returns the next time of the schedule after t and the note on
+// the run if the schedule has one
+func nextWithNote(schedule cron.Schedule, t time.Time) (time.Time, string) {
+	if noted, ok := schedule.(notedSchedule); ok {
+		return noted.next(t)
+	}
+	return schedule.Next(t), ""
+}
+
+// locationSchedule is a cron schedule in a location
+type locationSchedule struct {
+	schedule cron.Schedule
+	location *time.Location
+}
+
+func (schedule locationSchedule) Next(t time.Time) time.Time {
+	return schedule.schedule.Next(t.In(schedule.location))
+}
+
+// neverSchedule never runs, it is the schedule of jobs which only run once
+type neverSchedule struct{}
+
+func (schedule neverSchedule) Next(t time.Time) time.Time {
+	return time.Time{}
+}
+
+// mergedSchedule runs at the times of each of its schedules, once if several
+// run at the same time
+type mergedSchedule []cron.Schedule
+
+func (schedules mergedSchedule) Next(t time.Time) time.Time {
+	next, _ := schedules.next(t)
+	return next
+}
+
+func (schedules mergedSchedule) next(t time.Time) (time.Time, string) {
+	var first time.Time
+	firstNote := ""
+	for _, schedule := range schedules {
+		next, note := nextWithNote(schedule, t)
+		if !next.IsZero() && (first.IsZero() || next.Before(first)) {
+			first, firstNote = next, note
+		}
+	}
+	return first, firstNote
+}
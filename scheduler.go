@@ -0,0 +1,218 @@
+package godoit
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+	"github.com/robfig/cron"
+)
+
+// jobScheduler runs functions on schedules, such as a job's cronspec in its own
+// location, taking the time from a Clock. The next run of every function is kept in a
+// priority queue so one scheduler can run thousands of jobs with a single
+// timer. Functions can be set and removed while it is running without changing
+// when the others run.
+type jobScheduler struct {
+	clock Clock
+	entries map[string]*scheduleEntry
+	queue scheduleQueue
+	lock sync.Mutex
+	updates chan func()
+	stop chan bool
+	done chan bool
+}
+
+type scheduleEntry struct {
+	key string
+	schedule cron.Schedule
+	next time.Time
+	run func()
+	index int
+}
+
+func newJobScheduler(clock Clock) *jobScheduler {
+	return &jobScheduler{clock: clock, entries: make(map[string]*scheduleEntry)}
+}
+
+// Set adds a function to run on the schedule, replacing the function with the
+// same key. It returns once the function is scheduled. The function is called
+// in the scheduler's goroutine, so it must start anything which takes time in
+// another goroutine.
+func (scheduler *jobScheduler) Set(key string, schedule cron.Schedule, run func()) {
+	scheduler.update(func(now time.Time) {
+		scheduler.remove(key)
+		entry := &scheduleEntry{key: key, schedule: schedule, run: run, index: -1}
+		scheduler.entries[key] = entry
+		if !now.IsZero() {
+			scheduler.push(entry, now)
+		}
+	})
+}
+
+// Remove stops running the function with the key, returning whether there was
+// one
+func (scheduler *jobScheduler) Remove(key string) bool {
+	removed := false
+	scheduler.update(func(now time.Time) {
+		removed = scheduler.remove(key)
+	})
+	return removed
+}
+
+// Next returns when the function with the key next runs, or the zero time if
+// it is not scheduled or the scheduler is not running
+func (scheduler *jobScheduler) Next(key string) time.Time {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	if entry, ok := scheduler.entries[key]; ok {
+		return entry.next
+	}
+	return time.Time{}
+}
+
+// Len returns the number of functions scheduled
+func (scheduler *jobScheduler) Len() int {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	return len(scheduler.entries)
+}
+
+func (scheduler *jobScheduler) remove(key string) bool {
+	entry, ok := scheduler.entries[key]
+	if !ok {
+		return false
+	}
+	if entry.index >= 0 {
+		heap.Remove(&scheduler.queue, entry.index)
+	}
+	delete(scheduler.entries, key)
+	return true
+}
+
+// push sets the next run of the entry after now and queues it, entries which
+// never run again are not queued
+func (scheduler *jobScheduler) push(entry *scheduleEntry, now time.Time) {
+	entry.next = entry.schedule.Next(now)
+	if !entry.next.IsZero() {
+		heap.Push(&scheduler.queue, entry)
+	}
+}
+
+// update changes the entries, in the scheduler's goroutine if it is running so
+// that the timer is set for the changed entries before returning. The change
+// is given the current time if the scheduler is running, otherwise zero.
+func (scheduler *jobScheduler) update(change func(now time.Time)) {
+	if scheduler.stop == nil {
+		scheduler.lock.Lock()
+		defer scheduler.lock.Unlock()
+		change(time.Time{})
+		return
+	}
+	changed := make(chan bool)
+	scheduler.updates <- func() {
+		change(scheduler.clock.Now())
+		close(changed)
+	}
+	<-changed
+}
+
+// Start sets the timer for the first run before returning and runs the
+// functions in the background.
+func (scheduler *jobScheduler) Start() {
+	if scheduler.stop != nil {
+		return
+	}
+	scheduler.updates = make(chan func())
+	scheduler.stop = make(chan bool)
+	scheduler.done = make(chan bool)
+	scheduler.lock.Lock()
+	now := scheduler.clock.Now()
+	scheduler.queue = nil
+	for _, entry := range scheduler.entries {
+		entry.index = -1
+		scheduler.push(entry, now)
+	}
+	timer := scheduler.nextTimer(now)
+	scheduler.lock.Unlock()
+	go scheduler.run(timer)
+}
+
+// Stop stops running functions and waits for the scheduler to finish, runs
+// which have already started are not stopped.
+func (scheduler *jobScheduler) Stop() {
+	if scheduler.stop == nil {
+		return
+	}
+	close(scheduler.stop)
+	<-scheduler.done
+	scheduler.stop = nil
+}
+
+func (scheduler *jobScheduler) run(timer Timer) {
+	defer close(scheduler.done)
+	for {
+		select {
+		case now := <-timer.C():
+			scheduler.lock.Lock()
+			for len(scheduler.queue) > 0 && !scheduler.queue[0].next.After(now) {
+				entry := heap.Pop(&scheduler.queue).(*scheduleEntry)
+				entry.run()
+				scheduler.push(entry, now)
+			}
+			timer = scheduler.nextTimer(now)
+			scheduler.lock.Unlock()
+		case change := <-scheduler.updates:
+			// Entries which were due before the change run when the new timer
+			// fires, which is immediately
+			timer.Stop()
+			scheduler.lock.Lock()
+			change()
+			timer = scheduler.nextTimer(scheduler.clock.Now())
+			scheduler.lock.Unlock()
+		case <-scheduler.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// nextTimer sets a timer for the first entry in the queue
+func (scheduler *jobScheduler) nextTimer(now time.Time) Timer {
+	if len(scheduler.queue) == 0 {
+		// Nothing to run, sleep until stopped
+		return scheduler.clock.NewTimer(100000 * time.Hour)
+	}
+	return scheduler.clock.NewTimer(scheduler.queue[0].next.Sub(now))
+}
+
+// scheduleQueue is a heap of entries ordered by their next run
+type scheduleQueue []*scheduleEntry
+
+func (queue scheduleQueue) Len() int {
+	return len(queue)
+}
+
+func (queue scheduleQueue) Less(i, j int) bool {
+	return queue[i].next.Before(queue[j].next)
+}
+
+func (queue scheduleQueue) Swap(i, j int) {
+	queue[i], queue[j] = queue[j], queue[i]
+	queue[i].index = i
+	queue[j].index = j
+}
+
+func (queue *scheduleQueue) Push(x interface{}) {
+	entry := x.(*scheduleEntry)
+	entry.index = len(*queue)
+	*queue = append(*queue, entry)
+}
+
+func (queue *scheduleQueue) Pop() interface{} {
+	old := *queue
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	entry.index = -1
+	*queue = old[:len(old)-1]
+	return entry
+}
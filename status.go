@@ -1,6 +1,7 @@
-package main
+package godoit
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
 	"log"
@@ -9,13 +10,24 @@ import (
 	"time"
 )
 
-type StatusReporter func(jobSets map[string]*JobSet)
+// StatusReporter is given the status of the jobs periodically
+type StatusReporter interface {
+	Report(info GodoitInfo)
+}
+
+// StatusReporterFunc allows a function to be used as a StatusReporter
+type StatusReporterFunc func(info GodoitInfo)
+
+func (f StatusReporterFunc) Report(info GodoitInfo) {
+	f(info)
+}
 
 type GodoitInfo struct {
 	Time string				   `json:"time"`
 	Hostname string 		   `json:"hostname"`
 	JobInfo []JobCollection	   `json:"jobInfo"`
 	Environment map[string]string `json:"environment"`
+	Excluded []string `json:"excluded,omitempty"`
 }
 
 type JobCollection struct {
@@ -32,15 +44,46 @@ type JobInfo struct {
 	Timeout int `json:"timeout"`
 	Enabled bool `json:"enabled"`
 	Errors []string `json:"errors"`
+	User string `json:"user"`
+	Group string `json:"group"`
+	Limits ResourceLimits `json:"limits"`
+	LastRun *RunInfo `json:"lastRun,omitempty"`
+	Priority int `json:"priority"`
+	Jitter int `json:"jitter"`
+	NextRun string `json:"nextRun,omitempty"`
+	Executor string `json:"executor"`
+	NextRunNote string `json:"nextRunNote,omitempty"`
+	Specs []string `json:"specs"`
+	Inactive string `json:"inactive,omitempty"`
+	Runs int `json:"runs"`
+	Trigger string `json:"trigger,omitempty"`
 }
 
-func ToJson(jobSets map[string]*JobSet, statusEnvironment []string) []byte {
+type RunInfo struct {
+	Executor string `json:"executor"`
+	Jitter float64 `json:"jitter"`
+	QueueWait float64 `json:"queueWait"`
+	Start string `json:"start"`
+	Duration float64 `json:"duration"`
+	Error string `json:"error,omitempty"`
+	TimedOut bool `json:"timedOut"`
+	ExitCode int `json:"exitCode"`
+	HttpStatus int `json:"httpStatus,omitempty"`
+	PeakMemory uint64 `json:"peakMemory"`
+	CpuTime float64 `json:"cpuTime"`
+}
+
+// Status returns the status of the jobs in each job set, including the values
+// of the environment variables given
+func Status(jobSets map[string]*JobSet, statusEnvironment []string) GodoitInfo {
+	now := time.Now()
 	jobCollections := make([]JobCollection, len(jobSets))
 	i := 0
 	for _, jobSet := range jobSets {
 		jobs := make([]JobInfo, len(jobSet.jobs))
 		j := 0
-		for _, job := range jobSet.jobs {
+		for filename, job := range jobSet.jobs {
+			nextRun := jobSet.nextRun(filename, job, now)
 			jobs[j] =
 				JobInfo{
 					job.Name,
@@ -49,7 +92,20 @@ func ToJson(jobSets map[string]*JobSet, statusEnvironment []string) []byte {
 					job.Filepath,
 					int(job.Timeout.Seconds()),
 					job.Enabled,
-					job.Errors}
+					job.Errors,
+					job.User,
+					job.Group,
+					job.Limits,
+					runInfo(jobSet, filename),
+					job.Priority,
+					int(job.Jitter.Seconds()),
+					nextRunString(nextRun),
+					job.Executor,
+					nextRunNote(jobSet, job, now, nextRun),
+					cronSpecStrings(job.Schedules),
+					jobSet.inactive(filename, job, now),
+					jobSet.runCount(filename, job),
+					job.Trigger.Glob}
 			j++
 
 		}
@@ -58,7 +114,7 @@ func ToJson(jobSets map[string]*JobSet, statusEnvironment []string) []byte {
 	}
 
 	hostname, _ := os.Hostname()
-	time := time.Now().UTC().Format("20060102T15:04:05Z")
+	time := now.UTC().Format("20060102T15:04:05Z")
 
 	// Setup environment variables
 	environment := make(map[string]string)
@@ -66,24 +122,78 @@ func ToJson(jobSets map[string]*JobSet, statusEnvironment []string) []byte {
 		environment[environmentVariable] = os.Getenv(environmentVariable)
 	}
 
-	godoitInfo := &GodoitInfo{time,hostname,jobCollections, environment}
-	info, _ := json.Marshal(godoitInfo)
+	return GodoitInfo{time,hostname,jobCollections, environment, nil}
+}
+
+func ToJson(jobSets map[string]*JobSet, statusEnvironment []string) []byte {
+	info, _ := json.Marshal(Status(jobSets, statusEnvironment))
+	return info
+}
+
+func runInfo(jobSet *JobSet, filename string) *RunInfo {
+	result, ok := jobSet.lastResult(filename)
+	if !ok {
+		return nil
+	}
+	info := &RunInfo{
+		Executor: result.Executor,
+		Jitter: result.Jitter.Seconds(),
+		QueueWait: result.QueueWait.Seconds(),
+		Start: result.Start.UTC().Format("20060102T15:04:05Z"),
+		Duration: result.Duration.Seconds(),
+		TimedOut: result.TimedOut,
+		ExitCode: result.ExitCode,
+		HttpStatus: result.HTTPStatus,
+		PeakMemory: result.PeakMemory,
+		CpuTime: result.CPUTime.Seconds()}
+	if result.Error != nil {
+		info.Error = result.Error.Error()
+	}
 	return info
 }
 
-func StatusReporterFromScript(statusScript string, statusEnvironment []string, output io.Writer) StatusReporter {
+func nextRunString(nextRun time.Time) string {
+	if nextRun.IsZero() {
+		return ""
+	}
+	return nextRun.UTC().Format("20060102T15:04:05Z")
+}
+
+func cronSpecStrings(specs []CronSpec) []string {
+	strings := make([]string, len(specs))
+	for i, spec := range specs {
+		strings[i] = spec.String()
+	}
+	return strings
+}
+
+// nextRunNote returns the note on how daylight saving, the job's calendar or
+// the blackout windows changed the next run of the job, if they did
+func nextRunNote(jobSet *JobSet, job Job, now time.Time, nextRun time.Time) string {
+	next, note := jobSet.NextRun(job, now)
+	if next.IsZero() || nextRun.Before(next) || nextRun.Sub(next) > job.Jitter {
+		return ""
+	}
+	return note
+}
+
+// StatusReporterFromScript returns a StatusReporter which runs the script with
+// the status as JSON on its standard input, or an error if the script is not
+// defined
+func StatusReporterFromScript(statusScript string, output io.Writer) (StatusReporter, error) {
 	if len(statusScript) == 0 {
-		log.Fatalf("Status script is not defined")
+		return nil, fmt.Errorf("Status script is not defined")
 	}
 	statusScript = os.ExpandEnv(statusScript)
-	return func(jobSets map[string]*JobSet) {
+	return StatusReporterFunc(func(info GodoitInfo) {
 		cmd := exec.Command(statusScript)
 		log.Printf("Running status script: %s", statusScript)
 		cmd.Stdout = output
 		cmd.Stderr = output
 		pipe, _ := cmd.StdinPipe()
 		err := cmd.Start()
-		pipe.Write(ToJson(jobSets, statusEnvironment))
+		status, _ := json.Marshal(info)
+		pipe.Write(status)
 		pipe.Close()
 		if err != nil {
 			log.Printf("ERROR: Failed to execute status script %s", statusScript)
@@ -93,6 +203,5 @@ func StatusReporterFromScript(statusScript string, statusEnvironment []string, o
 		if err != nil {
 			log.Printf("ERROR: Status script completed with error code %s", statusScript)
 		}
-	}
-
+	}), nil
 }
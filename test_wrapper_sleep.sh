@@ -1,2 +1,2 @@
 #!/bin/bash
-sleep 100
\ No newline at end of file
+exec sleep 100
\ No newline at end of file
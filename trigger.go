@@ -0,0 +1,265 @@
+package godoit
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"github.com/fsnotify/fsnotify"
+)
+
+// TriggerFileEnv is the environment variable which holds the file that
+// triggered a run of a job
+const TriggerFileEnv = "GODOIT_TRIGGER_FILE"
+
+// defaultTriggerDebounce is how long after the last notification for a file a
+// triggered job runs if the job does not set a debounce
+const defaultTriggerDebounce = time.Second
+
+// triggerPollInterval is how often the files are checked for changes when
+// filesystem notifications are not available
+var triggerPollInterval = 10 * time.Second
+
+// triggerCheckInterval is how often changed files are checked to see if they
+// have settled
+var triggerCheckInterval = 100 * time.Millisecond
+
+// FileTrigger runs a job when a file matching a glob appears or changes
+type FileTrigger struct {
+	// Glob matches the files, relative to the job's directory if not absolute
+	Glob string
+	// Debounce is how long after the last notification for a file to wait
+	Debounce time.Duration
+	// Stable is how long the size and modification time of a file must not
+	// change before the job runs, so partly written files are not used
+	Stable time.Duration
+}
+
+// applyTriggerParameter parses a trigger of the form
+// 'file <glob> [debounce <duration>] [stable <duration>]'
+func applyTriggerParameter(job *Job, value string) {
+	parts := strings.Fields(value)
+	valid := len(parts) >= 2 && len(parts) % 2 == 0 && parts[0] == "file"
+	if valid {
+		_, err := filepath.Match(parts[1], "")
+		valid = err == nil
+	}
+	trigger := FileTrigger{Debounce: defaultTriggerDebounce}
+	for i := 2; valid && i < len(parts); i += 2 {
+		d, err := time.ParseDuration(parts[i+1])
+		if err != nil || d < 0 {
+			valid = false
+		} else if parts[i] == "debounce" {
+			trigger.Debounce = d
+		} else if parts[i] == "stable" {
+			trigger.Stable = d
+		} else {
+			valid = false
+		}
+	}
+	if !valid {
+		job.Errors = append(job.Errors, fmt.Sprintf("Invalid trigger: '%s'", value))
+		return
+	}
+	trigger.Glob = parts[1]
+	job.Trigger = trigger
+}
+
+// triggerPattern returns the glob of the job's trigger as an absolute path
+func triggerPattern(job Job) string {
+	if filepath.IsAbs(job.Trigger.Glob) {
+		return job.Trigger.Glob
+	}
+	return filepath.Join(filepath.Dir(job.Filepath), job.Trigger.Glob)
+}
+
+// fileState identifies a version of a file
+type fileState struct {
+	size int64
+	modTime time.Time
+}
+
+// pendingFile is a file which has changed and has not yet settled
+type pendingFile struct {
+	state fileState
+	notified time.Time
+	changed time.Time
+}
+
+// triggerWatcher watches for files matching a pattern which appear or change,
+// using filesystem notifications for the directory of the pattern or polling
+// if these are not available. Files which exist when it starts do not trigger
+// a run until they change. Times are taken from the clock, so tests can
+// control when files settle.
+type triggerWatcher struct {
+	pattern string
+	trigger FileTrigger
+	clock Clock
+	run func(path string)
+	seen map[string]fileState
+	pending map[string]*pendingFile
+	stop chan bool
+	done chan bool
+}
+
+func newTriggerWatcher(pattern string, trigger FileTrigger, clock Clock, run func(path string)) *triggerWatcher {
+	return &triggerWatcher{
+		pattern: pattern,
+		trigger: trigger,
+		clock: clock,
+		run: run,
+		seen: globFileStates(pattern),
+		pending: make(map[string]*pendingFile),
+		stop: make(chan bool),
+		done: make(chan bool)}
+}
+
+// startTriggerWatcher starts watching for the files matching the pattern. A
+// directory with a wildcard cannot be watched, so the files are polled for.
+func startTriggerWatcher(pattern string, trigger FileTrigger, clock Clock, run func(path string)) *triggerWatcher {
+	watcher := newTriggerWatcher(pattern, trigger, clock, run)
+	directory := filepath.Dir(pattern)
+	var notifications *fsnotify.Watcher
+	var err error
+	if strings.ContainsAny(directory, `*?[\`) {
+		err = fmt.Errorf("%s has a wildcard", directory)
+	} else if notifications, err = fsnotify.NewWatcher(); err == nil {
+		if err = notifications.Add(directory); err != nil {
+			notifications.Close()
+		}
+	}
+	var poll Timer
+	if err != nil {
+		log.Printf("  Polling for %s every %s, %s", pattern, triggerPollInterval, err)
+		notifications = nil
+		poll = clock.NewTimer(triggerPollInterval)
+	}
+	go watcher.watch(notifications, poll, clock.NewTimer(triggerCheckInterval))
+	return watcher
+}
+
+// Stop stops watching and waits for the watcher to finish
+func (watcher *triggerWatcher) Stop() {
+	close(watcher.stop)
+	<-watcher.done
+}
+
+// watch handles the notifications, or polls for the files with the poll timer
+// if there are none, and checks the changed files until it is stopped. The
+// timers are only set again once a poll or check is done, so a FakeClock waits
+// for it.
+func (watcher *triggerWatcher) watch(notifications *fsnotify.Watcher, poll Timer, check Timer) {
+	defer close(watcher.done)
+	var events chan fsnotify.Event
+	var errors chan error
+	var polls <-chan time.Time
+	if notifications != nil {
+		defer notifications.Close()
+		events, errors = notifications.Events, notifications.Errors
+	} else {
+		polls = poll.C()
+		defer func() { poll.Stop() }()
+	}
+	defer func() { check.Stop() }()
+
+	for {
+		select {
+		case event := <-events:
+			if matched, _ := filepath.Match(watcher.pattern, event.Name); !matched {
+				continue
+			}
+			if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+				delete(watcher.seen, event.Name)
+				delete(watcher.pending, event.Name)
+			} else {
+				watcher.notify(event.Name, watcher.clock.Now())
+			}
+		case err := <-errors:
+			log.Printf("ERROR: Watching for %s, %s", watcher.pattern, err)
+		case now := <-polls:
+			states := globFileStates(watcher.pattern)
+			for path, state := range states {
+				if pending, ok := watcher.pending[path]; state != watcher.seen[path] && (!ok || pending.state != state) {
+					watcher.notify(path, now)
+				}
+			}
+			for path := range watcher.seen {
+				if _, ok := states[path]; !ok {
+					delete(watcher.seen, path)
+				}
+			}
+			poll = watcher.clock.NewTimer(triggerPollInterval)
+			polls = poll.C()
+		case now := <-check.C():
+			watcher.check(now)
+			check = watcher.clock.NewTimer(triggerCheckInterval)
+		case <-watcher.stop:
+			return
+		}
+	}
+}
+
+// notify records a change to the file
+func (watcher *triggerWatcher) notify(path string, now time.Time) {
+	state, err := statFile(path)
+	if err != nil {
+		delete(watcher.pending, path)
+		return
+	}
+	pending, ok := watcher.pending[path]
+	if !ok {
+		pending = &pendingFile{state: state, changed: now}
+		watcher.pending[path] = pending
+	} else if pending.state != state {
+		pending.state = state
+		pending.changed = now
+	}
+	pending.notified = now
+}
+
+// check runs the job for the changed files which have settled, once for each
+// version of a file
+func (watcher *triggerWatcher) check(now time.Time) {
+	for path, pending := range watcher.pending {
+		state, err := statFile(path)
+		if err != nil {
+			delete(watcher.pending, path)
+			continue
+		}
+		if state != pending.state {
+			pending.state = state
+			pending.changed = now
+			continue
+		}
+		if now.Sub(pending.notified) < watcher.trigger.Debounce || now.Sub(pending.changed) < watcher.trigger.Stable {
+			continue
+		}
+		delete(watcher.pending, path)
+		if watcher.seen[path] != state {
+			watcher.seen[path] = state
+			watcher.run(path)
+		}
+	}
+}
+
+func statFile(path string) (fileState, error) {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return fileState{}, fmt.Errorf("%s is not a file", path)
+	}
+	return fileState{info.Size(), info.ModTime()}, nil
+}
+
+// globFileStates returns the state of each file matching the pattern
+func globFileStates(pattern string) map[string]fileState {
+	states := make(map[string]fileState)
+	paths, _ := filepath.Glob(pattern)
+	for _, path := range paths {
+		if state, err := statFile(path); err == nil {
+			states[path] = state
+		}
+	}
+	return states
+}